@@ -0,0 +1,14 @@
+package entity
+
+//table, append only record of admin changes
+type AuditLog struct {
+	ID         int64   `db:"id" json:"id"`
+	Actor      string  `db:"actor" json:"actor"`
+	Action     string  `db:"action" json:"action"`
+	TargetType string  `db:"target_type" json:"target_type"`
+	TargetUUID string  `db:"target_uuid" json:"target_uuid"`
+	Before     *string `db:"before_snapshot" json:"before,omitempty"`
+	After      *string `db:"after_snapshot" json:"after,omitempty"`
+	ClientIP   string  `db:"client_ip" json:"client_ip"`
+	CreatedAt  int64   `db:"created_at" json:"created_at"`
+}
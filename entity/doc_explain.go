@@ -0,0 +1,8 @@
+package entity
+
+//table, the warnings of the last plan analysis of a doc
+type DocExplain struct {
+	DocUUID    string `db:"doc_uuid" json:"doc_uuid"`
+	Warnings   string `db:"warnings" json:"warnings"`
+	AnalyzedAt int64  `db:"analyzed_at" json:"analyzed_at"`
+}
@@ -0,0 +1,17 @@
+package entity
+
+//table, one row per execution of a mutation doc
+type MutationLog struct {
+	ID             int64   `db:"id" json:"id"`
+	DocUUID        string  `db:"doc_uuid" json:"doc_uuid"`
+	Path           string  `db:"path" json:"path"`
+	IdempotencyKey *string `db:"idempotency_key" json:"idempotency_key,omitempty"`
+	RequestBody    string  `db:"request_body" json:"request_body"`
+	RequestHash    string  `db:"request_hash" json:"request_hash"`
+	Status         string  `db:"status" json:"status"`
+	StatusCode     int     `db:"status_code" json:"status_code"`
+	Response       *string `db:"response" json:"response,omitempty"`
+	ClientIP       string  `db:"client_ip" json:"client_ip"`
+	CreatedAt      *int    `db:"created_at" json:"created_at,omitempty"`
+	UpdatedAt      *int    `db:"updated_at" json:"updated_at,omitempty"`
+}
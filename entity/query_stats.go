@@ -0,0 +1,29 @@
+package entity
+
+//table, the stats of the queries of a doc and composition key over the last window
+type QueryStats struct {
+	DocPath       string  `db:"doc_path" json:"doc_path"`
+	Key           string  `db:"composition_key" json:"key"`
+	Count         int64   `db:"count" json:"count"`
+	Errors        int64   `db:"errors" json:"errors"`
+	ErrorRate     float64 `db:"error_rate" json:"error_rate"`
+	RowsReturned  int64   `db:"rows_returned" json:"rows_returned"`
+	P50Ms         float64 `db:"p50_ms" json:"p50_ms"`
+	P95Ms         float64 `db:"p95_ms" json:"p95_ms"`
+	MaxMs         float64 `db:"max_ms" json:"max_ms"`
+	WindowSeconds int64   `db:"window_seconds" json:"window_seconds"`
+	UpdatedAt     int64   `db:"updated_at" json:"updated_at"`
+}
+
+//table, one row per query slower than the threshold
+type SlowQuery struct {
+	ID           int64   `db:"id" json:"id"`
+	DocPath      string  `db:"doc_path" json:"doc_path"`
+	Key          string  `db:"composition_key" json:"key"`
+	DurationMs   float64 `db:"duration_ms" json:"duration_ms"`
+	RowsReturned int64   `db:"rows_returned" json:"rows_returned"`
+	Statement    string  `db:"statement" json:"statement"`
+	Args         string  `db:"args" json:"args"`
+	Error        *string `db:"error" json:"error,omitempty"`
+	CreatedAt    int64   `db:"created_at" json:"created_at"`
+}
@@ -0,0 +1,44 @@
+// Package sqltest holds the checks shared by the tests of the packages that
+// generate sql.
+package sqltest
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var (
+	quotedIdentPattern = regexp.MustCompile("`[A-Za-z_][A-Za-z0-9_]*`")
+	placeholderPattern = regexp.MustCompile(`:[A-Za-z0-9_]+`)
+)
+
+// HostileValues must only ever reach the sql as bound args
+var HostileValues = []string{
+	"x' OR '1'='1",
+	"1; DROP TABLE doc",
+	"a`b",
+	"-- comment",
+	"/* c */",
+	`\' x`,
+}
+
+// AssertSafeSQL fails when sql holds a raw value, quote, comment or statement
+// separator, or a backtick outside of a quoted identifier
+func AssertSafeSQL(t testing.TB, sql string, raw ...string) {
+	t.Helper()
+
+	for _, v := range raw {
+		if v != "" && strings.Contains(sql, v) {
+			t.Errorf("raw value %q reached the sql %q", v, sql)
+		}
+	}
+
+	rest := quotedIdentPattern.ReplaceAllString(sql, "")
+	rest = placeholderPattern.ReplaceAllString(rest, "")
+	for _, s := range []string{"`", "'", `"`, ";", "--", "/*", "#", `\`} {
+		if strings.Contains(rest, s) {
+			t.Errorf("sql %q holds %q outside of quoted identifiers", sql, s)
+		}
+	}
+}
@@ -1,6 +1,9 @@
 package main
 
 import (
+	"context"
+	"expvar"
+	"fmt"
 	"github.com/gin-gonic/gin"
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/jmoiron/sqlx"
@@ -8,45 +11,86 @@ import (
 	log "github.com/sirupsen/logrus"
 	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
-	_ "gitlab.com/beehplus/sql-compose/docs"
 	"gitlab.com/beehplus/sql-compose/restapi"
-	"github.com/gin-contrib/cors"
+	"gitlab.com/beehplus/sql-compose/token"
+	_ "gitlab.com/beehplus/sql-compose/token/mes"
+	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 )
 
 //env
 type Specification struct {
-	Debug      bool
-	Port       string
-	BasePath   string
-	Dsn        string
-	User       string
-	Rate       float32
-	Timeout    time.Duration
-	ColorCodes map[string]int
+	Debug         bool
+	Port          string
+	BasePath      string
+	Dsn           string
+	User          string
+	Rate          float32
+	Timeout       time.Duration
+	ColorCodes    map[string]int
+	Tokens        string
+	DictionaryTTL time.Duration `default:"10m"`
+	WritableDocs  []string
+	JWTSecret     string
+	Burst         int
+	// max queries running at once per doc and per database config
+	DocConcurrency int
+	DbConcurrency  int
+	// allowed cross origins, none refuses cross origin requests
+	CorsOrigins     []string
+	CorsMethods     []string `default:"GET,POST,PUT,PATCH,DELETE,HEAD,OPTIONS"`
+	CorsHeaders     []string `default:"Origin,Content-Length,Content-Type,X-Requested-With,X-CSRF-TOKEN,Authorization,Idempotency-Key"`
+	CorsCredentials bool
+	CorsMaxAge      time.Duration `default:"12h"`
+	HSTSMaxAge      time.Duration
+	Swagger         bool `default:"true"`
+	MaskKey         string
+	// roles of the callers allowed to preview unsaved docs
+	PreviewRoles []string
+	// queries running at least SlowQuery are logged, 0 disables the log
+	SlowQuery   time.Duration `default:"1s"`
+	StatsWindow time.Duration `default:"1h"`
+	StatsFlush  time.Duration `default:"1m"`
+	// timeout of each readiness and database status check
+	HealthTimeout time.Duration `default:"2s"`
+	// readyz fails for DrainDelay on shutdown before the server stops
+	// accepting requests, so load balancers stop routing to it first
+	DrainDelay time.Duration `default:"5s"`
+	// requests in flight may finish within DrainTimeout on shutdown, their
+	// queries are cancelled after it
+	DrainTimeout time.Duration `default:"30s"`
+	// max run time of a mutation, a pending one is retried after it
+	MutationTimeout time.Duration `default:"5m"`
 }
 
-// @title sql-compose-api
-// @version 1.0
-// @description This is a api for sql-compose.
-// @termsOfService http://swagger.io/terms/
-
-// @contact.name API Support
-// @contact.url http://www.swagger.io/support
-// @contact.email support@swagger.io
-
-// @license.name Apache 2.0
-// @license.url http://www.apache.org/licenses/LICENSE-2.0.html
+// String leaves the secrets out of the startup log
+func (s Specification) String() string {
+	type plain Specification
+	p := plain(s)
+	for _, secret := range []*string{&p.Dsn, &p.JWTSecret, &p.MaskKey} {
+		if *secret != "" {
+			*secret = "[redacted]"
+		}
+	}
+	return fmt.Sprintf("%+v", p)
+}
 
-// @host localhost:8080
-// @BasePath /
 func main() {
 	var s Specification
 	if err := envconfig.Process("sqlcompose", &s); err != nil {
 		log.Fatal(err)
 	}
 
+	// the container health check runs the binary itself, the image is not
+	// guaranteed to ship curl
+	if len(os.Args) > 1 && os.Args[1] == "healthcheck" {
+		os.Exit(healthcheck(listenAddr(s.Port), s.HealthTimeout))
+	}
+
 	log.Info(s)
 	log.SetLevel(log.DebugLevel)
 
@@ -57,44 +101,138 @@ func main() {
 	}
 	defer db.Close()
 
+	if err := restapi.EnsureTables(db); err != nil {
+		log.Fatal(err)
+	}
+
 	//b, _ := base64.StdEncoding.DecodeString("MjAyMDA1MjY3OQ==")
 	//fmt.Println(string(b))
 
 	router := gin.Default()
 
-	url := ginSwagger.URL("http://localhost" +
-		s.Port + "/swagger/doc.json")
-	router.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler, url))
+	tokens, err := token.LoadRegistry(s.Tokens, s.DictionaryTTL)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	handler := restapi.NewHandler(db)
+	handler := restapi.NewHandler(db, tokens, restapi.Config{
+		WritableDocs:   s.WritableDocs,
+		JWTSecret:      s.JWTSecret,
+		Rate:           float64(s.Rate),
+		Burst:          s.Burst,
+		DocConcurrency: s.DocConcurrency,
+		DbConcurrency:  s.DbConcurrency,
+		BasePath:       s.BasePath,
+		CORS: restapi.CORSPolicy{
+			Origins:     s.CorsOrigins,
+			Methods:     s.CorsMethods,
+			Headers:     s.CorsHeaders,
+			Credentials: s.CorsCredentials,
+			MaxAge:      s.CorsMaxAge,
+		},
+		HSTSMaxAge:      s.HSTSMaxAge,
+		MaskKey:         s.MaskKey,
+		PreviewRoles:    s.PreviewRoles,
+		SlowQuery:       s.SlowQuery,
+		StatsWindow:     s.StatsWindow,
+		HealthTimeout:   s.HealthTimeout,
+		MutationTimeout: s.MutationTimeout,
+	})
+	expvar.Publish("sqlcompose_limits", handler.Metrics())
+	statsCtx, stopStats := context.WithCancel(context.Background())
+	statsDone := make(chan struct{})
+	go func() {
+		handler.PersistStats(statsCtx, s.StatsFlush)
+		close(statsDone)
+	}()
+
+	// requests in flight are waited for on shutdown
+	router.Use(handler.Track)
 
 	// 跨域
-	router.Use(cors.New(cors.Config{
-		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "HEAD", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Length", "Content-Type", "X-Requested-With", "X-CSRF-TOKEN"},
-		ExposeHeaders:    []string{"Content-Length"},
-		AllowCredentials: true,
-		AllowAllOrigins:  true,
-		MaxAge:           12 * time.Hour,
-	}))
-
-	router.GET("/doc", handler.GetDocList)
-	router.POST("/doc/:uuid", handler.UpdateDoc)
-	router.PATCH("/doc", handler.AddDoc)
-	router.POST("/doc", handler.PostDoc)
-	router.GET("/doc/:uuid", handler.GetDocDetailByUuid)
-	router.DELETE("/doc/:uuid", handler.DeleteDoc)
-
-	router.GET("/dns", handler.GetDbConfigList)
-	router.DELETE("/dns/:uuid", handler.DeleteDbConfigByUUID)
-	router.POST("/dns/:uuid", handler.UpdateDbConfigByUUID)
-	router.POST("/dns", handler.AddDbConfig)
-
-	router.POST(s.BasePath+"*path", handler.GetResult)
-
-	if err := router.Run(s.Port); err != nil {
-		log.Fatal(err)
+	router.Use(handler.SecurityHeaders, handler.CORS)
+
+	router.Use(handler.Authenticate)
+
+	routes := handler.Routes()
+	restapi.Mount(router, routes)
+
+	if s.Swagger {
+		router.GET("/openapi.json", restapi.OpenAPIHandler(restapi.OpenAPIInfo{
+			Title:       "sql-compose-api",
+			Version:     "1.0",
+			Description: "This is a api for sql-compose.",
+		}, routes))
+		// relative to /swagger/index.html, so it works behind a proxy
+		url := ginSwagger.URL("../openapi.json")
+		router.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler, url))
+	}
+
+	srv := &http.Server{Addr: listenAddr(s.Port), Handler: router}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-quit
+	log.Infof("%s received, draining in %s for up to %s", sig, s.DrainDelay, s.DrainTimeout)
+
+	// readyz fails from now on and new requests are still served until the
+	// load balancers noticed. The requests in flight may then finish until
+	// the deadline, their queries are cancelled after it.
+	handler.Drain()
+	time.Sleep(s.DrainDelay)
+	ctx, cancel := context.WithTimeout(context.Background(), s.DrainTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Warn(err)
+		handler.CancelQueries()
+		srv.Close()
+	}
+
+	// cancelled handlers may still be using the pools
+	handler.Wait()
+	handler.Close()
+	stopStats()
+	<-statsDone
+	log.Info("shutdown completed")
+}
+
+// listenAddr is the address the server listens on for the Port setting
+func listenAddr(port string) string {
+	if port == "" {
+		return ":80"
+	}
+	return port
+}
+
+// healthcheck asks /healthz of the server listening on addr, it returns the
+// exit status of the check
+func healthcheck(addr string, timeout time.Duration) int {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+		host = "localhost"
+	}
+
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Get("http://" + net.JoinHostPort(host, port) + "/healthz")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		fmt.Fprintln(os.Stderr, resp.Status)
+		return 1
 	}
+	return 0
 }
 
 func init() {
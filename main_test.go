@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestListenAddr(t *testing.T) {
+	for port, want := range map[string]string{
+		"":               ":80",
+		":8080":          ":8080",
+		"127.0.0.1:8080": "127.0.0.1:8080",
+	} {
+		if got := listenAddr(port); got != want {
+			t.Errorf("port %q listens on %q, want %q", port, got, want)
+		}
+	}
+}
+
+func healthServer(status int, delay time.Duration) (*httptest.Server, string) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/healthz" {
+			http.NotFound(w, r)
+			return
+		}
+		time.Sleep(delay)
+		w.WriteHeader(status)
+	}))
+	_, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+	return srv, port
+}
+
+func TestHealthcheck(t *testing.T) {
+	srv, port := healthServer(http.StatusOK, 0)
+	defer srv.Close()
+
+	// a server listening on every interface is asked on localhost
+	for _, addr := range []string{":" + port, "0.0.0.0:" + port, "127.0.0.1:" + port} {
+		if code := healthcheck(addr, time.Second); code != 0 {
+			t.Errorf("healthy %s exits %d", addr, code)
+		}
+	}
+}
+
+func TestHealthcheckFails(t *testing.T) {
+	unhealthy, unhealthyPort := healthServer(http.StatusServiceUnavailable, 0)
+	defer unhealthy.Close()
+	slow, slowPort := healthServer(http.StatusOK, time.Second)
+	defer slow.Close()
+	down, downPort := healthServer(http.StatusOK, 0)
+	down.Close()
+
+	for _, addr := range []string{
+		"127.0.0.1:" + unhealthyPort,
+		"127.0.0.1:" + slowPort,
+		"127.0.0.1:" + downPort,
+		"8080",
+	} {
+		if code := healthcheck(addr, 100*time.Millisecond); code != 1 {
+			t.Errorf("%s exits %d, want 1", addr, code)
+		}
+	}
+}
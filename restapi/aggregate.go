@@ -0,0 +1,129 @@
+package restapi
+
+import (
+	"fmt"
+	"gitlab.com/beehplus/sql-compose/sqlident"
+	"strings"
+)
+
+var aggregateFuncs = map[string]string{
+	"sum":            "SUM(%s)",
+	"count":          "COUNT(%s)",
+	"avg":            "AVG(%s)",
+	"min":            "MIN(%s)",
+	"max":            "MAX(%s)",
+	"count_distinct": "COUNT(DISTINCT %s)",
+}
+
+// AggregateSpec declares what a doc can be grouped by and measured with. From
+// is the FROM clause (tables and joins) the generated query selects from; the
+// request filters are applied to it through the %where token as usual.
+type AggregateSpec struct {
+	From       string               `yaml:"from"`
+	Dimensions []AggregateDimension `yaml:"dimensions,omitempty"`
+	Measures   []AggregateMeasure   `yaml:"measures,omitempty"`
+}
+
+type AggregateDimension struct {
+	Name string `yaml:"name"`
+	Expr string `yaml:"expr"`
+}
+
+type AggregateMeasure struct {
+	Name string `yaml:"name"`
+	Func string `yaml:"func"`
+	Expr string `yaml:"expr"`
+}
+
+func (spec *AggregateSpec) dimension(name string) (AggregateDimension, bool) {
+	for _, d := range spec.Dimensions {
+		if d.Name == name {
+			return d, true
+		}
+	}
+	return AggregateDimension{}, false
+}
+
+func (spec *AggregateSpec) measure(name string) (AggregateMeasure, bool) {
+	for _, m := range spec.Measures {
+		if m.Name == name {
+			return m, true
+		}
+	}
+	return AggregateMeasure{}, false
+}
+
+// buildAggregate generates the subject (and, when grouping, the total) sql of
+// an aggregate request. Clients only pick declared names; every expression
+// comes from the doc. The returned SortSpec allows ordering by the selected
+// dimensions and measures.
+func buildAggregate(spec *AggregateSpec, req *GetResultAggregate) (map[string]string, SortSpec, error) {
+	var sorts SortSpec
+
+	if spec == nil || strings.TrimSpace(spec.From) == "" {
+		return nil, sorts, fmt.Errorf("this doc does not support aggregation")
+	}
+	if len(req.Measures) == 0 {
+		return nil, sorts, fmt.Errorf("aggregate requires at least one measure")
+	}
+
+	var selects, groups []string
+	used := map[string]bool{}
+
+	for _, name := range req.Dimensions {
+		d, ok := spec.dimension(name)
+		if !ok {
+			return nil, sorts, fmt.Errorf("%s is not a declared dimension", name)
+		}
+		alias, err := sqlident.Quote(d.Name)
+		if err != nil {
+			return nil, sorts, err
+		}
+		if used[name] {
+			return nil, sorts, fmt.Errorf("%s is selected more than once", name)
+		}
+		used[name] = true
+
+		selects = append(selects, fmt.Sprintf("%s AS %s", d.Expr, alias))
+		groups = append(groups, d.Expr)
+		sorts.Keys = append(sorts.Keys, SortKey{Name: d.Name, Expr: alias})
+		sorts.Default = append(sorts.Default, SortItem{Attr: d.Name})
+	}
+
+	for _, name := range req.Measures {
+		m, ok := spec.measure(name)
+		if !ok {
+			return nil, sorts, fmt.Errorf("%s is not a declared measure", name)
+		}
+		alias, err := sqlident.Quote(m.Name)
+		if err != nil {
+			return nil, sorts, err
+		}
+		format, ok := aggregateFuncs[strings.ToLower(m.Func)]
+		if !ok {
+			return nil, sorts, fmt.Errorf("measure %s has unsupported func %s", m.Name, m.Func)
+		}
+		if used[name] {
+			return nil, sorts, fmt.Errorf("%s is selected more than once", name)
+		}
+		used[name] = true
+
+		selects = append(selects, fmt.Sprintf("%s AS %s", fmt.Sprintf(format, m.Expr), alias))
+		sorts.Keys = append(sorts.Keys, SortKey{Name: m.Name, Expr: alias})
+	}
+
+	subject := map[string]string{}
+	if len(groups) == 0 {
+		subject["subject"] = fmt.Sprintf("SELECT %s FROM %s %%where",
+			strings.Join(selects, ", "), spec.From)
+		return subject, sorts, nil
+	}
+
+	groupBy := strings.Join(groups, ", ")
+	subject["subject"] = fmt.Sprintf("SELECT %s FROM %s %%where GROUP BY %s %%order_by %%limit",
+		strings.Join(selects, ", "), spec.From, groupBy)
+	subject["total"] = fmt.Sprintf("SELECT COUNT(*) FROM (SELECT 1 FROM %s %%where GROUP BY %s) AS aggregate_groups",
+		spec.From, groupBy)
+
+	return subject, sorts, nil
+}
@@ -0,0 +1,97 @@
+package restapi
+
+import (
+	"testing"
+)
+
+var testAggregateSpec = &AggregateSpec{
+	From: "orders o JOIN customer c ON c.id = o.customer_id",
+	Dimensions: []AggregateDimension{
+		{Name: "state", Expr: "o.state"},
+		{Name: "month", Expr: "DATE_FORMAT(o.created_at, '%Y-%m')"},
+	},
+	Measures: []AggregateMeasure{
+		{Name: "revenue", Func: "sum", Expr: "o.amount"},
+		{Name: "customers", Func: "COUNT_DISTINCT", Expr: "c.id"},
+		{Name: "median", Func: "median", Expr: "o.amount"},
+		{Name: "bad`name", Func: "sum", Expr: "o.amount"},
+	},
+}
+
+func TestBuildAggregateGrouped(t *testing.T) {
+	req := &GetResultAggregate{
+		Dimensions: []string{"state", "month"},
+		Measures:   []string{"revenue", "customers"},
+	}
+
+	subject, sorts, err := buildAggregate(testAggregateSpec, req)
+	if err != nil {
+		t.Fatalf("buildAggregate: %v", err)
+	}
+
+	groupBy := "o.state, DATE_FORMAT(o.created_at, '%Y-%m')"
+	want := "SELECT o.state AS `state`, DATE_FORMAT(o.created_at, '%Y-%m') AS `month`, " +
+		"SUM(o.amount) AS `revenue`, COUNT(DISTINCT c.id) AS `customers` " +
+		"FROM orders o JOIN customer c ON c.id = o.customer_id %where GROUP BY " + groupBy + " %order_by %limit"
+	if subject["subject"] != want {
+		t.Errorf("subject is\n%s\nwant\n%s", subject["subject"], want)
+	}
+
+	wantTotal := "SELECT COUNT(*) FROM (SELECT 1 FROM orders o JOIN customer c ON c.id = o.customer_id " +
+		"%where GROUP BY " + groupBy + ") AS aggregate_groups"
+	if subject["total"] != wantTotal {
+		t.Errorf("total is\n%s\nwant\n%s", subject["total"], wantTotal)
+	}
+
+	for _, name := range []string{"state", "month", "revenue", "customers"} {
+		if _, ok := sorts.expr(name); !ok {
+			t.Errorf("%s is not sortable", name)
+		}
+	}
+	if len(sorts.Default) != 2 || sorts.Default[0].Attr != "state" || sorts.Default[1].Attr != "month" {
+		t.Errorf("default order is %+v, want the dimensions", sorts.Default)
+	}
+}
+
+func TestBuildAggregateUngrouped(t *testing.T) {
+	subject, sorts, err := buildAggregate(testAggregateSpec, &GetResultAggregate{Measures: []string{"revenue"}})
+	if err != nil {
+		t.Fatalf("buildAggregate: %v", err)
+	}
+
+	want := "SELECT SUM(o.amount) AS `revenue` FROM orders o JOIN customer c ON c.id = o.customer_id %where"
+	if subject["subject"] != want {
+		t.Errorf("subject is %q, want %q", subject["subject"], want)
+	}
+	if _, ok := subject["total"]; ok {
+		t.Errorf("an ungrouped aggregate has a total query")
+	}
+	if len(sorts.Default) != 0 {
+		t.Errorf("an ungrouped aggregate has a default order %+v", sorts.Default)
+	}
+}
+
+func TestBuildAggregateRejects(t *testing.T) {
+	cases := []struct {
+		spec *AggregateSpec
+		req  GetResultAggregate
+	}{
+		{nil, GetResultAggregate{Measures: []string{"revenue"}}},
+		{&AggregateSpec{From: " "}, GetResultAggregate{Measures: []string{"revenue"}}},
+		{testAggregateSpec, GetResultAggregate{Dimensions: []string{"state"}}},
+		{testAggregateSpec, GetResultAggregate{Measures: []string{"o.amount"}}},
+		{testAggregateSpec, GetResultAggregate{Measures: []string{"SUM(o.amount)"}}},
+		{testAggregateSpec, GetResultAggregate{Dimensions: []string{"o.state"}, Measures: []string{"revenue"}}},
+		{testAggregateSpec, GetResultAggregate{Measures: []string{"median"}}},
+		{testAggregateSpec, GetResultAggregate{Measures: []string{"bad`name"}}},
+		{testAggregateSpec, GetResultAggregate{Measures: []string{"revenue", "revenue"}}},
+		{testAggregateSpec, GetResultAggregate{Dimensions: []string{"state", "state"}, Measures: []string{"revenue"}}},
+	}
+
+	for _, c := range cases {
+		req := c.req
+		if subject, _, err := buildAggregate(c.spec, &req); err == nil {
+			t.Errorf("aggregate %+v was accepted: %q", c.req, subject["subject"])
+		}
+	}
+}
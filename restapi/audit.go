@@ -0,0 +1,231 @@
+package restapi
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+	"gitlab.com/beehplus/sql-compose/entity"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	auditTargetDoc      = "doc"
+	auditTargetDbConfig = "dbconfig"
+
+	maxAuditExportRows = 100000
+)
+
+// dbConfigSnapshot is the audit view of a database config, with the
+// password of the dsn redacted
+type dbConfigSnapshot struct {
+	entity.DataBaseConfig
+	Dsn string `json:"dsn"`
+}
+
+func redactDSN(dsn string) string {
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return "[redacted]"
+	}
+	if cfg.Passwd != "" {
+		cfg.Passwd = "[redacted]"
+	}
+	return cfg.FormatDSN()
+}
+
+// snapshot returns the current state of an audit target as json, nil when
+// it does not exist
+func (s *Service) snapshot(q sqlx.Queryer, targetType string, uuid string) *string {
+	var v interface{}
+
+	switch targetType {
+	case auditTargetDoc:
+		var doc entity.Doc
+		if err := sqlx.Get(q, &doc, "SELECT * FROM doc WHERE uuid=?", uuid); err != nil {
+			return nil
+		}
+		v = doc
+	case auditTargetDbConfig:
+		var conf entity.DataBaseConfig
+		if err := sqlx.Get(q, &conf, "SELECT * FROM database_config WHERE uuid=?", uuid); err != nil {
+			return nil
+		}
+		v = dbConfigSnapshot{DataBaseConfig: conf, Dsn: redactDSN(conf.Dsn)}
+	}
+
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		log.Error(err)
+		return nil
+	}
+	str := string(encoded)
+	return &str
+}
+
+// audit appends a record of an admin change made in tx
+func (s *Service) audit(tx *sqlx.Tx, c *gin.Context, action string, targetType string, uuid string, before *string) error {
+	_, err := tx.NamedExec(`INSERT INTO audit_log (actor,action,target_type,target_uuid,before_snapshot,after_snapshot,client_ip,created_at) VALUES (:actor,:action,:target_type,:target_uuid,:before_snapshot,:after_snapshot,:client_ip,:created_at)`,
+		map[string]interface{}{
+			"actor":           callerOf(c).Actor(),
+			"action":          action,
+			"target_type":     targetType,
+			"target_uuid":     uuid,
+			"before_snapshot": before,
+			"after_snapshot":  s.snapshot(tx, targetType, uuid),
+			"client_ip":       c.ClientIP(),
+			"created_at":      time.Now().Unix(),
+		})
+	return err
+}
+
+// commitAudited records the change made in tx and commits both. A change
+// that can not be recorded is rolled back and the request fails.
+func (s *Service) commitAudited(tx *sqlx.Tx, c *gin.Context, action string, targetType string, uuid string, before *string) bool {
+	err := s.audit(tx, c, action, targetType, uuid, before)
+	if err == nil {
+		err = tx.Commit()
+	} else {
+		tx.Rollback()
+	}
+	if err != nil {
+		log.Error(err)
+		c.JSON(http.StatusInternalServerError, Error{
+			Code:    50002,
+			Message: "can not record the change",
+		})
+		return false
+	}
+	return true
+}
+
+// auditFilter builds the where clause of the audit queries from the actor,
+// target, from and to (unix seconds) query params
+func auditFilter(c *gin.Context) (string, []interface{}, error) {
+	var where []string
+	var args []interface{}
+
+	if actor := c.Query("actor"); actor != "" {
+		where = append(where, "actor=?")
+		args = append(args, actor)
+	}
+	if target := c.Query("target"); target != "" {
+		where = append(where, "target_uuid=?")
+		args = append(args, target)
+	}
+	for param, cond := range map[string]string{"from": "created_at>=?", "to": "created_at<?"} {
+		v := c.Query(param)
+		if v == "" {
+			continue
+		}
+		ts, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return "", nil, fmt.Errorf("%s must be a unix timestamp", param)
+		}
+		where = append(where, cond)
+		args = append(args, ts)
+	}
+
+	if len(where) == 0 {
+		return "", args, nil
+	}
+	return " WHERE " + strings.Join(where, " AND "), args, nil
+}
+
+func (s *Service) GetAuditLogList(c *gin.Context) {
+	where, args, err := auditFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Error{
+			Code:    40006,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	pageIndex, _ := strconv.ParseInt(c.DefaultQuery("page_index", "1"), 10, 64)
+	pageLimit, _ := strconv.ParseInt(c.DefaultQuery("page_limit", "20"), 10, 64)
+	if pageIndex < 1 {
+		pageIndex = 1
+	}
+	if pageLimit < 1 || pageLimit > 500 {
+		pageLimit = 20
+	}
+
+	var list AuditLogList
+	list.Data = []*entity.AuditLog{}
+	err = s.Db.Select(&list.Data, "SELECT * FROM audit_log"+where+" ORDER BY created_at DESC, id DESC LIMIT ?, ?",
+		append(args, (pageIndex-1)*pageLimit, pageLimit)...)
+	if err != nil {
+		log.Error(err)
+		c.JSON(http.StatusBadRequest, nil)
+		return
+	}
+
+	err = s.Db.Get(&list, "SELECT COUNT(id) AS total FROM audit_log"+where, args...)
+	if err != nil {
+		log.Error(err)
+		c.JSON(http.StatusBadRequest, nil)
+		return
+	}
+
+	c.JSON(http.StatusOK, list)
+}
+
+func (s *Service) ExportAuditLog(c *gin.Context) {
+	where, args, err := auditFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Error{
+			Code:    40006,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	rows, err := s.Db.Queryx("SELECT * FROM audit_log"+where+" ORDER BY created_at, id LIMIT ?",
+		append(args, maxAuditExportRows)...)
+	if err != nil {
+		log.Error(err)
+		c.JSON(http.StatusBadRequest, nil)
+		return
+	}
+	defer rows.Close()
+
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="audit-%d.csv"`, time.Now().Unix()))
+	c.Status(http.StatusOK)
+
+	w := csv.NewWriter(c.Writer)
+	w.Write([]string{"id", "created_at", "actor", "action", "target_type", "target_uuid", "client_ip", "before", "after"})
+	for rows.Next() {
+		var record entity.AuditLog
+		if err := rows.StructScan(&record); err != nil {
+			log.Error(err)
+			break
+		}
+		w.Write([]string{
+			strconv.FormatInt(record.ID, 10),
+			time.Unix(record.CreatedAt, 0).UTC().Format(time.RFC3339),
+			record.Actor,
+			record.Action,
+			record.TargetType,
+			record.TargetUUID,
+			record.ClientIP,
+			stringOrEmpty(record.Before),
+			stringOrEmpty(record.After),
+		})
+	}
+	w.Flush()
+}
+
+func stringOrEmpty(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
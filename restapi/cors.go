@@ -0,0 +1,190 @@
+package restapi
+
+import (
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"gitlab.com/beehplus/sql-compose/entity"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the doc cors policies are reloaded after this long, so that docs changed
+// through another instance are picked up
+const corsCacheTTL = time.Minute
+
+// CORSPolicy decides which cross origin requests are allowed. No origins
+// means cross origin requests are refused. The origin "*" allows any
+// origin, it never comes with credentials.
+type CORSPolicy struct {
+	Origins     []string      `yaml:"origins,omitempty"`
+	Methods     []string      `yaml:"methods,omitempty"`
+	Headers     []string      `yaml:"headers,omitempty"`
+	Credentials bool          `yaml:"credentials,omitempty"`
+	MaxAge      time.Duration `yaml:"max_age,omitempty"`
+}
+
+// override returns the policy of a doc, methods, headers and max age it
+// leaves out are taken from base
+func (p CORSPolicy) override(base CORSPolicy) CORSPolicy {
+	if len(p.Methods) == 0 {
+		p.Methods = base.Methods
+	}
+	if len(p.Headers) == 0 {
+		p.Headers = base.Headers
+	}
+	if p.MaxAge == 0 {
+		p.MaxAge = base.MaxAge
+	}
+	return p
+}
+
+// allowOrigin returns the value of Access-Control-Allow-Origin for origin,
+// empty when it is not allowed
+func (p CORSPolicy) allowOrigin(origin string) string {
+	for _, o := range p.Origins {
+		if o == "*" {
+			return "*"
+		}
+		if strings.EqualFold(o, origin) {
+			return origin
+		}
+	}
+	return ""
+}
+
+// CORS is a middleware applying the configured CORS policy, or the policy of
+// the doc when a doc path declares composition.cors
+func (s *Service) CORS(c *gin.Context) {
+	origin := c.GetHeader("Origin")
+	if origin == "" {
+		c.Next()
+		return
+	}
+
+	policy := s.conf.CORS
+	if docPolicy := s.docCORS(c.Request.URL.Path); docPolicy != nil {
+		policy = docPolicy.override(policy)
+	}
+
+	preflight := c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != ""
+
+	c.Writer.Header().Add("Vary", "Origin")
+	allowed := policy.allowOrigin(origin)
+	if allowed == "" {
+		if preflight {
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+		c.Next()
+		return
+	}
+
+	h := c.Writer.Header()
+	h.Set("Access-Control-Allow-Origin", allowed)
+	if policy.Credentials && allowed != "*" {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+
+	if !preflight {
+		h.Set("Access-Control-Expose-Headers", "Content-Length, Retry-After, Idempotent-Replayed")
+		c.Next()
+		return
+	}
+
+	h.Set("Access-Control-Allow-Methods", strings.Join(policy.Methods, ", "))
+	h.Set("Access-Control-Allow-Headers", strings.Join(policy.Headers, ", "))
+	if policy.MaxAge > 0 {
+		h.Set("Access-Control-Max-Age", strconv.FormatInt(int64(policy.MaxAge/time.Second), 10))
+	}
+	c.AbortWithStatus(http.StatusNoContent)
+}
+
+// docCORS returns the cors section of the doc served at urlPath, if any
+func (s *Service) docCORS(urlPath string) *CORSPolicy {
+	if s.conf.BasePath == "" || !strings.HasPrefix(urlPath, s.conf.BasePath) {
+		return nil
+	}
+	path := "/" + strings.TrimPrefix(urlPath[len(s.conf.BasePath):], "/")
+
+	policies, err := s.cors.get(s.loadDocCORS)
+	if err != nil {
+		log.Warn(err)
+		return nil
+	}
+	return policies[path]
+}
+
+// loadDocCORS reads the cors sections of all docs, keyed by doc path
+func (s *Service) loadDocCORS() (map[string]*CORSPolicy, error) {
+	var docs []entity.Doc
+	if err := s.Db.Select(&docs, "SELECT path, content FROM doc WHERE content IS NOT NULL"); err != nil {
+		return nil, err
+	}
+
+	policies := map[string]*CORSPolicy{}
+	for _, doc := range docs {
+		spec, err := parseDocSpec([]byte(*doc.Content))
+		if err != nil {
+			log.Warnf("doc %s: %v", doc.Path, err)
+			continue
+		}
+		if spec.Composition.CORS != nil {
+			policies[doc.Path] = spec.Composition.CORS
+		}
+	}
+	return policies, nil
+}
+
+// corsCache holds the cors policies of all docs, so that requests with an
+// Origin do not query and parse their doc. Saving a doc invalidates it.
+type corsCache struct {
+	mu       sync.Mutex
+	ttl      time.Duration
+	policies map[string]*CORSPolicy
+	loadedAt time.Time
+}
+
+func newCORSCache(ttl time.Duration) *corsCache {
+	return &corsCache{ttl: ttl}
+}
+
+func (c *corsCache) get(load func() (map[string]*CORSPolicy, error)) (map[string]*CORSPolicy, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.policies != nil && time.Since(c.loadedAt) < c.ttl {
+		return c.policies, nil
+	}
+	policies, err := load()
+	if err != nil {
+		return nil, err
+	}
+	c.policies, c.loadedAt = policies, time.Now()
+	return policies, nil
+}
+
+func (c *corsCache) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.policies = nil
+}
+
+// SecurityHeaders is a middleware setting the standard security headers of
+// an api. The swagger ui is left out of the content security policy.
+func (s *Service) SecurityHeaders(c *gin.Context) {
+	h := c.Writer.Header()
+	h.Set("X-Content-Type-Options", "nosniff")
+	h.Set("X-Frame-Options", "DENY")
+	h.Set("Referrer-Policy", "no-referrer")
+	if !strings.HasPrefix(c.Request.URL.Path, "/swagger/") {
+		h.Set("Content-Security-Policy", "default-src 'none'; frame-ancestors 'none'")
+	}
+
+	if s.conf.HSTSMaxAge > 0 && (c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https") {
+		h.Set("Strict-Transport-Security", "max-age="+strconv.FormatInt(int64(s.conf.HSTSMaxAge/time.Second), 10))
+	}
+	c.Next()
+}
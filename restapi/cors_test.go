@@ -0,0 +1,125 @@
+package restapi
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func corsService(policies map[string]*CORSPolicy) *Service {
+	s := &Service{
+		conf: Config{
+			BasePath: "/api",
+			CORS: CORSPolicy{
+				Origins: []string{"https://app.example.com"},
+				Methods: []string{"GET", "POST"},
+				Headers: []string{"Authorization"},
+				MaxAge:  time.Hour,
+			},
+		},
+		cors: newCORSCache(time.Minute),
+	}
+	s.cors.policies, s.cors.loadedAt = policies, time.Now()
+	return s
+}
+
+func serveCORS(s *Service, method string, path string, origin string, preflight bool) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(s.CORS)
+	router.Handle(method, "/api/*path", func(c *gin.Context) { c.String(http.StatusOK, "ok") })
+
+	req := httptest.NewRequest(method, path, nil)
+	req.Header.Set("Origin", origin)
+	if preflight {
+		req.Header.Set("Access-Control-Request-Method", "GET")
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestCORSDocOverride(t *testing.T) {
+	s := corsService(map[string]*CORSPolicy{
+		"/orders": {Origins: []string{"https://shop.example.com"}, Credentials: true},
+		"/public": {Origins: []string{"*"}, Credentials: true, Methods: []string{"GET"}},
+	})
+
+	// the deployment policy applies to docs without a cors section
+	w := serveCORS(s, http.MethodGet, "/api/stock", "https://app.example.com", false)
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "https://app.example.com" {
+		t.Errorf("default policy allows %q", got)
+	}
+	w = serveCORS(s, http.MethodGet, "/api/stock", "https://shop.example.com", false)
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("an origin of another doc was allowed: %q", got)
+	}
+
+	// a doc policy replaces the origins and keeps the defaults it leaves out
+	w = serveCORS(s, http.MethodOptions, "/api/orders", "https://shop.example.com", true)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("preflight answered %d", w.Code)
+	}
+	h := w.Header()
+	if h.Get("Access-Control-Allow-Origin") != "https://shop.example.com" || h.Get("Access-Control-Allow-Credentials") != "true" {
+		t.Errorf("doc policy answered %v", h)
+	}
+	if h.Get("Access-Control-Allow-Methods") != "GET, POST" || h.Get("Access-Control-Allow-Headers") != "Authorization" || h.Get("Access-Control-Max-Age") != "3600" {
+		t.Errorf("doc policy did not take the defaults: %v", h)
+	}
+
+	w = serveCORS(s, http.MethodOptions, "/api/orders", "https://app.example.com", true)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("preflight of an origin the doc leaves out answered %d", w.Code)
+	}
+
+	// a wildcard never comes with credentials
+	w = serveCORS(s, http.MethodGet, "/api/public", "https://any.example.com", false)
+	if w.Header().Get("Access-Control-Allow-Origin") != "*" || w.Header().Get("Access-Control-Allow-Credentials") != "" {
+		t.Errorf("wildcard policy answered %v", w.Header())
+	}
+}
+
+func TestCORSCache(t *testing.T) {
+	loads := 0
+	policy := &CORSPolicy{Origins: []string{"*"}}
+	load := func() (map[string]*CORSPolicy, error) {
+		loads++
+		return map[string]*CORSPolicy{"/orders": policy}, nil
+	}
+
+	cache := newCORSCache(time.Minute)
+	for i := 0; i < 3; i++ {
+		policies, err := cache.get(load)
+		if err != nil || policies["/orders"] != policy {
+			t.Fatalf("get returned %v, %v", policies, err)
+		}
+	}
+	if loads != 1 {
+		t.Errorf("policies were loaded %d times, want once", loads)
+	}
+
+	cache.invalidate()
+	cache.get(load)
+	if loads != 2 {
+		t.Errorf("an invalidated cache was not reloaded")
+	}
+
+	cache.loadedAt = time.Now().Add(-2 * time.Minute)
+	cache.get(load)
+	if loads != 3 {
+		t.Errorf("an expired cache was not reloaded")
+	}
+
+	cache.invalidate()
+	if _, err := cache.get(func() (map[string]*CORSPolicy, error) { return nil, fmt.Errorf("down") }); err == nil {
+		t.Errorf("a failed load was not returned")
+	}
+	cache.get(load)
+	if loads != 4 {
+		t.Errorf("a failed load was cached")
+	}
+}
@@ -0,0 +1,145 @@
+package restapi
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/wangxb07/sqlcomposer"
+)
+
+const cursorPageMode = "cursor"
+
+// CursorSpec declares the unique, ordered key a doc can be seeked on. Attr is
+// the column name in result rows, Expr the sql expression compared against.
+type CursorSpec struct {
+	Attr string `yaml:"attr"`
+	Expr string `yaml:"expr"`
+	Dir  string `yaml:"dir,omitempty"`
+}
+
+type cursorToken struct {
+	Attr string      `json:"a"`
+	Val  interface{} `json:"v"`
+}
+
+// cursorPage drives keyset pagination: it seeks past the last key of the
+// previous page instead of using OFFSET.
+type cursorPage struct {
+	spec  CursorSpec
+	dir   sqlcomposer.Direction
+	after interface{}
+	size  int64
+}
+
+func newCursorPage(spec *CursorSpec, cursor string, size int64) (*cursorPage, error) {
+	if spec == nil || spec.Attr == "" || spec.Expr == "" {
+		return nil, fmt.Errorf("this doc does not support cursor pagination")
+	}
+	if size <= 0 {
+		return nil, fmt.Errorf("page_limit must be greater than 0 in cursor mode")
+	}
+
+	dir, err := parseDirection(spec.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &cursorPage{
+		spec: *spec,
+		dir:  dir,
+		size: size,
+	}
+
+	if cursor != "" {
+		p.after, err = decodeCursor(cursor, spec.Attr)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return p, nil
+}
+
+func decodeCursor(cursor string, attr string) (interface{}, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, fmt.Errorf("cursor is malformed")
+	}
+
+	var token cursorToken
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	if err := dec.Decode(&token); err != nil || token.Val == nil {
+		return nil, fmt.Errorf("cursor is malformed")
+	}
+	if token.Attr != attr {
+		return nil, fmt.Errorf("cursor does not belong to this doc")
+	}
+
+	if n, ok := token.Val.(json.Number); ok {
+		return n.String(), nil
+	}
+	return token.Val, nil
+}
+
+func encodeCursor(attr string, val interface{}) (string, error) {
+	raw, err := json.Marshal(cursorToken{Attr: attr, Val: val})
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// Seek condition, empty for the first page
+func (p *cursorPage) condition() sqlcomposer.ConditionStmt {
+	if p.after == nil {
+		return sqlcomposer.ConditionStmt{}
+	}
+
+	op := ">"
+	if p.dir == sqlcomposer.DESC {
+		op = "<"
+	}
+
+	clause := fmt.Sprintf("%s %s :cursor_key", p.spec.Expr, op)
+	return sqlcomposer.ConditionStmt{
+		Clause:      clause,
+		Arg:         map[string]interface{}{"cursor_key": p.after},
+		ClauseSlice: map[string]string{"cursor_key": clause},
+	}
+}
+
+func (p *cursorPage) orderBy() sqlcomposer.OrderBy {
+	return sqlcomposer.OrderBy{{Name: p.spec.Expr, Direction: p.dir}}
+}
+
+// One extra row is fetched to find out whether there is a next page.
+func (p *cursorPage) fetchSize() int64 {
+	return p.size + 1
+}
+
+// paginate trims the look-ahead row and returns the cursor of the next page,
+// or an empty string on the last page.
+func (p *cursorPage) paginate(rows []interface{}) ([]interface{}, string, error) {
+	if int64(len(rows)) <= p.size {
+		return rows, "", nil
+	}
+
+	rows = rows[:p.size]
+	if len(rows) == 0 {
+		return rows, "", nil
+	}
+
+	last, ok := rows[len(rows)-1].(map[string]interface{})
+	if !ok {
+		return rows, "", fmt.Errorf("unexpected result row")
+	}
+	val, ok := last[p.spec.Attr]
+	if !ok {
+		return rows, "", fmt.Errorf("cursor attr %s is not selected by the subject", p.spec.Attr)
+	}
+
+	next, err := encodeCursor(p.spec.Attr, val)
+	return rows, next, err
+}
@@ -0,0 +1,150 @@
+package restapi
+
+import (
+	"encoding/base64"
+	"github.com/wangxb07/sqlcomposer"
+	"testing"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	cases := []struct {
+		val  interface{}
+		want interface{}
+	}{
+		{int64(42), "42"},
+		{9007199254740993, "9007199254740993"},
+		{"2020-06-01 10:00:00", "2020-06-01 10:00:00"},
+		{"x' OR '1'='1", "x' OR '1'='1"},
+	}
+
+	for _, c := range cases {
+		cursor, err := encodeCursor("id", c.val)
+		if err != nil {
+			t.Fatalf("encodeCursor(%v): %v", c.val, err)
+		}
+		got, err := decodeCursor(cursor, "id")
+		if err != nil {
+			t.Fatalf("decodeCursor(%q): %v", cursor, err)
+		}
+		if got != c.want {
+			t.Errorf("cursor of %v decodes to %#v, want %#v", c.val, got, c.want)
+		}
+	}
+}
+
+func TestDecodeCursorRejects(t *testing.T) {
+	other, _ := encodeCursor("created_at", 1)
+
+	for _, cursor := range []string{
+		"!!",
+		base64.RawURLEncoding.EncodeToString([]byte("not json")),
+		base64.RawURLEncoding.EncodeToString([]byte(`{"a":"id"}`)),
+		base64.RawURLEncoding.EncodeToString([]byte(`{"a":"id","v":null}`)),
+		other,
+	} {
+		if v, err := decodeCursor(cursor, "id"); err == nil {
+			t.Errorf("cursor %q was accepted as %v", cursor, v)
+		}
+	}
+}
+
+func TestNewCursorPage(t *testing.T) {
+	spec := &CursorSpec{Attr: "id", Expr: "o.id"}
+
+	for _, c := range []struct {
+		spec *CursorSpec
+		size int64
+	}{
+		{nil, 10},
+		{&CursorSpec{Attr: "id"}, 10},
+		{&CursorSpec{Expr: "o.id"}, 10},
+		{spec, 0},
+		{&CursorSpec{Attr: "id", Expr: "o.id", Dir: "sideways"}, 10},
+	} {
+		if _, err := newCursorPage(c.spec, "", c.size); err == nil {
+			t.Errorf("cursor page of %+v with size %d was accepted", c.spec, c.size)
+		}
+	}
+
+	if _, err := newCursorPage(spec, "garbage!", 10); err == nil {
+		t.Errorf("malformed cursor was accepted")
+	}
+}
+
+func TestCursorPageCondition(t *testing.T) {
+	cursor, _ := encodeCursor("id", 7)
+
+	for _, c := range []struct {
+		dir    string
+		clause string
+	}{
+		{"", "o.id > :cursor_key"},
+		{"asc", "o.id > :cursor_key"},
+		{"DESC", "o.id < :cursor_key"},
+	} {
+		p, err := newCursorPage(&CursorSpec{Attr: "id", Expr: "o.id", Dir: c.dir}, cursor, 10)
+		if err != nil {
+			t.Fatalf("newCursorPage: %v", err)
+		}
+
+		stmt := p.condition()
+		if stmt.Clause != c.clause {
+			t.Errorf("dir %q seeks with %q, want %q", c.dir, stmt.Clause, c.clause)
+		}
+		if stmt.Arg["cursor_key"] != "7" {
+			t.Errorf("dir %q binds %v, want 7", c.dir, stmt.Arg["cursor_key"])
+		}
+
+		order := p.orderBy()
+		if len(order) != 1 || order[0].Name != "o.id" || order[0].Direction != p.dir {
+			t.Errorf("dir %q orders by %+v", c.dir, order)
+		}
+	}
+
+	first, err := newCursorPage(&CursorSpec{Attr: "id", Expr: "o.id"}, "", 10)
+	if err != nil {
+		t.Fatalf("newCursorPage: %v", err)
+	}
+	if !first.condition().IsEmpty() {
+		t.Errorf("first page seeks with %q", first.condition().Clause)
+	}
+	if first.orderBy()[0].Direction != sqlcomposer.ASC {
+		t.Errorf("default direction is %s", first.orderBy()[0].Direction)
+	}
+}
+
+func TestCursorPagePaginate(t *testing.T) {
+	p, err := newCursorPage(&CursorSpec{Attr: "id", Expr: "o.id"}, "", 2)
+	if err != nil {
+		t.Fatalf("newCursorPage: %v", err)
+	}
+	if p.fetchSize() != 3 {
+		t.Errorf("fetchSize is %d, want 3", p.fetchSize())
+	}
+
+	row := func(id int) interface{} { return map[string]interface{}{"id": id} }
+
+	rows, next, err := p.paginate([]interface{}{row(1), row(2)})
+	if err != nil || next != "" || len(rows) != 2 {
+		t.Errorf("last page returned %d rows, next %q, err %v", len(rows), next, err)
+	}
+
+	rows, next, err = p.paginate([]interface{}{row(1), row(2), row(3)})
+	if err != nil || len(rows) != 2 {
+		t.Fatalf("full page returned %d rows, err %v", len(rows), err)
+	}
+	if after, err := decodeCursor(next, "id"); err != nil || after != "2" {
+		t.Errorf("next cursor %q decodes to %v, %v, want 2", next, after, err)
+	}
+
+	if _, _, err := p.paginate([]interface{}{
+		map[string]interface{}{"name": "a"}, row(2), row(3),
+	}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, _, err := p.paginate([]interface{}{
+		row(1), map[string]interface{}{"name": "b"}, row(3),
+	}); err == nil {
+		t.Errorf("a last row without the cursor attr was accepted")
+	}
+}
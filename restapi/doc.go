@@ -0,0 +1,65 @@
+package restapi
+
+import (
+	"github.com/wangxb07/sqlcomposer"
+	"gitlab.com/beehplus/sql-compose/token"
+	"gopkg.in/yaml.v2"
+)
+
+// DocSpec holds the sections of a doc yaml that sql-compose-api understands
+// on top of sqlcomposer.SqlApiDoc. sqlcomposer ignores these keys, so both
+// are decoded from the same content.
+type DocSpec struct {
+	Info struct {
+		// Kind is empty for query docs, or mutation
+		Kind string `yaml:"kind,omitempty"`
+	} `yaml:"info"`
+	Composition struct {
+		Sorts      SortSpec       `yaml:"sorts,omitempty"`
+		Cursor     *CursorSpec    `yaml:"cursor,omitempty"`
+		Aggregates *AggregateSpec `yaml:"aggregates,omitempty"`
+		// EAV tokens declared by the doc itself, keyed by token name
+		EAV      map[string]*token.EAVSpec `yaml:"eav,omitempty"`
+		Mutation *MutationSpec             `yaml:"mutation,omitempty"`
+		Limits   *LimitSpec                `yaml:"limits,omitempty"`
+		Params   map[string]*ParamSpec     `yaml:"params,omitempty"`
+		Security *SecuritySpec             `yaml:"security,omitempty"`
+		// Masking policies keyed by result field
+		Masking map[string]*MaskSpec `yaml:"masking,omitempty"`
+		// CORS replaces the deployment policy, e.g. for public endpoints
+		CORS *CORSPolicy `yaml:"cors,omitempty"`
+	} `yaml:"composition"`
+}
+
+func (spec *DocSpec) isMutation() bool {
+	return spec.Info.Kind == docKindMutation
+}
+
+// checkDocStatements validates the sql of a doc, and the sections that shape
+// it, before it is saved
+func checkDocStatements(doc *sqlcomposer.SqlApiDoc, spec *DocSpec, writable bool) error {
+	if spec.isMutation() {
+		return checkMutation(spec.Composition.Mutation)
+	}
+	if err := checkDocParams(spec.Composition.Params); err != nil {
+		return err
+	}
+	if err := checkDocSecurity(spec.Composition.Security, doc.Composition.Subject); err != nil {
+		return err
+	}
+	if err := checkDocMasking(spec); err != nil {
+		return err
+	}
+	if writable {
+		return nil
+	}
+	return checkDocReadOnly(doc.Composition.Subject)
+}
+
+func parseDocSpec(content []byte) (*DocSpec, error) {
+	var spec DocSpec
+	if err := yaml.Unmarshal(content, &spec); err != nil {
+		return nil, err
+	}
+	return &spec, nil
+}
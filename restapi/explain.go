@@ -0,0 +1,314 @@
+package restapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+	"gitlab.com/beehplus/sql-compose/entity"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// access types of tables read in full, by rows or by an index scan
+var fullScanAccessTypes = map[string]bool{
+	"ALL":   true,
+	"index": true,
+}
+
+// ExplainDoc explains the rendered queries of a saved doc for a filter set.
+// The warnings of the last analysis are kept and shown in the doc detail.
+func (s *Service) ExplainDoc(c *gin.Context) {
+	var docEntity entity.Doc
+	if err := s.Db.Get(&docEntity, "SELECT * FROM doc WHERE uuid=?", c.Param("uuid")); err != nil {
+		log.Error(err)
+		c.JSON(http.StatusNotFound, Error{
+			Code:    40005,
+			Message: "this document does not exist",
+		})
+		return
+	}
+
+	var req GetResultRequest
+	if err := c.BindJSON(&req); err != nil {
+		log.Error(err)
+		c.JSON(http.StatusBadRequest, Error{
+			Code:    40006,
+			Message: "filter params error: " + err.Error(),
+		})
+		return
+	}
+
+	s.serveResult(c, docEntity.Path, &docEntity, &req, false, resultExplain)
+}
+
+// serveExplain runs EXPLAIN FORMAT=JSON on the queries, in read only
+// transactions, and answers the summary of the plans
+func (s *Service) serveExplain(c *gin.Context, db *sqlx.DB, docEntity *entity.Doc, req *GetResultRequest, queries []compositionQuery, sql map[string]string) {
+	explains := make([]compositionQuery, len(queries))
+	for i, q := range queries {
+		explains[i] = compositionQuery{
+			Key:   explainKeyPrefix + q.Key,
+			Query: "EXPLAIN FORMAT=JSON " + q.Query,
+			Args:  q.Args,
+		}
+	}
+
+	ctx, cancel := s.queryContext(c.Request.Context())
+	defer cancel()
+
+	plans, err := runCompositions(ctx, db, explains, true)
+	if err != nil {
+		log.Error(err)
+		c.JSON(http.StatusBadRequest, Error{
+			Code:    40022,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	result := ExplainResult{
+		SQL:        sql,
+		AnalyzedAt: time.Now().Unix(),
+	}
+	for _, plan := range plans {
+		summary, err := summarizePlan(strings.TrimPrefix(plan.Key, explainKeyPrefix), plan.Rows)
+		if err != nil {
+			log.Error(err)
+			c.JSON(http.StatusBadRequest, Error{
+				Code:    40022,
+				Message: err.Error(),
+			})
+			return
+		}
+		result.Plans = append(result.Plans, summary)
+		result.Warnings = append(result.Warnings, summary.warnings()...)
+	}
+
+	result.UnindexedFilters = unindexedFilters(filterAttrs(req), result.Plans)
+	for _, attr := range result.UnindexedFilters {
+		result.Warnings = append(result.Warnings, fmt.Sprintf("filter %s has no usable index", attr))
+	}
+
+	if docEntity.UUID != nil {
+		s.recordExplain(*docEntity.UUID, result.Warnings, result.AnalyzedAt)
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// recordExplain keeps the warnings of the last analysis of a doc
+func (s *Service) recordExplain(uuid string, warnings []string, analyzedAt int64) {
+	encoded, err := json.Marshal(warnings)
+	if err != nil {
+		log.Warn(err)
+		return
+	}
+	_, err = s.Db.Exec(`INSERT INTO doc_explain (doc_uuid, warnings, analyzed_at) VALUES (?, ?, ?)
+		ON DUPLICATE KEY UPDATE warnings=VALUES(warnings), analyzed_at=VALUES(analyzed_at)`,
+		uuid, string(encoded), analyzedAt)
+	if err != nil {
+		log.Warn(err)
+	}
+}
+
+// forgetExplain drops the analysis of a doc whose content changed
+func (s *Service) forgetExplain(uuid string) {
+	if _, err := s.Db.Exec("DELETE FROM doc_explain WHERE doc_uuid=?", uuid); err != nil {
+		log.Warn(err)
+	}
+}
+
+// docDetail adds the warnings of the last analysis to a doc
+func (s *Service) docDetail(doc entity.Doc) DocDetail {
+	detail := DocDetail{Doc: doc}
+	if doc.UUID == nil {
+		return detail
+	}
+
+	var explain entity.DocExplain
+	if err := s.Db.Get(&explain, "SELECT * FROM doc_explain WHERE doc_uuid=?", *doc.UUID); err != nil {
+		return detail
+	}
+	if err := json.Unmarshal([]byte(explain.Warnings), &detail.PlanWarnings); err != nil {
+		log.Warn(err)
+		return detail
+	}
+	detail.PlanAnalyzedAt = &explain.AnalyzedAt
+	return detail
+}
+
+// summarizePlan reads the json plan mysql answers to EXPLAIN FORMAT=JSON,
+// rows holds a single row with a single column
+func summarizePlan(key string, rows []interface{}) (*PlanSummary, error) {
+	if len(rows) != 1 {
+		return nil, fmt.Errorf("explain of %s answered %d rows", key, len(rows))
+	}
+	row, ok := rows[0].(map[string]interface{})
+	if !ok || len(row) != 1 {
+		return nil, fmt.Errorf("explain of %s is not a json plan", key)
+	}
+
+	var plan map[string]interface{}
+	for _, v := range row {
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("explain of %s is not a json plan", key)
+		}
+		if err := json.Unmarshal([]byte(s), &plan); err != nil {
+			return nil, fmt.Errorf("explain of %s is not a json plan: %v", key, err)
+		}
+	}
+
+	summary := &PlanSummary{Key: key, Plan: plan}
+	summary.walk(plan)
+	return summary, nil
+}
+
+// walk collects the tables and the sort and temporary table flags of a plan
+// node and its children. MariaDB names the flags filesort and
+// temporary_table and the rows rows.
+func (p *PlanSummary) walk(node interface{}) {
+	switch n := node.(type) {
+	case []interface{}:
+		for _, child := range n {
+			p.walk(child)
+		}
+	case map[string]interface{}:
+		if v, ok := n["using_filesort"].(bool); ok && v {
+			p.Filesort = true
+		}
+		if v, ok := n["using_temporary_table"].(bool); ok && v {
+			p.TemporaryTable = true
+		}
+		if _, ok := n["filesort"]; ok {
+			p.Filesort = true
+		}
+		if _, ok := n["temporary_table"]; ok {
+			p.TemporaryTable = true
+		}
+
+		if name, ok := n["table_name"].(string); ok {
+			table := PlanTable{
+				Table:      name,
+				AccessType: planString(n["access_type"]),
+				Key:        planString(n["key"]),
+				Condition:  planString(n["attached_condition"]),
+			}
+			if keys, ok := n["possible_keys"].([]interface{}); ok {
+				for _, k := range keys {
+					table.PossibleKeys = append(table.PossibleKeys, planString(k))
+				}
+			}
+			if rows, ok := n["rows_examined_per_scan"].(float64); ok {
+				table.RowsExamined = int64(rows)
+			} else if rows, ok := n["rows"].(float64); ok {
+				table.RowsExamined = int64(rows)
+			}
+
+			p.Tables = append(p.Tables, table)
+			p.EstimatedRows += table.RowsExamined
+			if fullScanAccessTypes[table.AccessType] {
+				p.FullScans = append(p.FullScans, name)
+			}
+		}
+
+		keys := make([]string, 0, len(n))
+		for k := range n {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			p.walk(n[k])
+		}
+	}
+}
+
+func (p *PlanSummary) warnings() []string {
+	var warnings []string
+	for _, t := range p.Tables {
+		if fullScanAccessTypes[t.AccessType] {
+			warnings = append(warnings, fmt.Sprintf("%s: full scan of %s, about %d rows", p.Key, t.Table, t.RowsExamined))
+		}
+	}
+	if p.Filesort {
+		warnings = append(warnings, fmt.Sprintf("%s: sorts with a filesort", p.Key))
+	}
+	if p.TemporaryTable {
+		warnings = append(warnings, fmt.Sprintf("%s: uses a temporary table", p.Key))
+	}
+	return warnings
+}
+
+func planString(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	return ""
+}
+
+// filterAttrs returns the attrs of the flat filters and of the leaves of the
+// where tree, sorted and without duplicates
+func filterAttrs(req *GetResultRequest) []string {
+	seen := map[string]bool{}
+	for _, f := range req.Filters {
+		seen[f.Attr] = true
+	}
+
+	var walk func(n *FilterNode)
+	walk = func(n *FilterNode) {
+		if n == nil {
+			return
+		}
+		if n.isLeaf() {
+			seen[n.Attr] = true
+			return
+		}
+		for _, child := range n.And {
+			walk(child)
+		}
+		for _, child := range n.Or {
+			walk(child)
+		}
+		walk(n.Not)
+	}
+	walk(req.Where)
+
+	attrs := make([]string, 0, len(seen))
+	for attr := range seen {
+		if attr != "" {
+			attrs = append(attrs, attr)
+		}
+	}
+	sort.Strings(attrs)
+	return attrs
+}
+
+// unindexedFilters returns the attrs whose column is only checked against
+// rows of a table read without an index
+func unindexedFilters(attrs []string, plans []*PlanSummary) []string {
+	var unindexed []string
+	for _, attr := range attrs {
+		column := "`" + attr + "`"
+		if dot := strings.LastIndex(attr, "."); dot >= 0 {
+			column = "`" + attr[:dot] + "`.`" + attr[dot+1:] + "`"
+		}
+
+	plans:
+		for _, p := range plans {
+			for _, t := range p.Tables {
+				if !strings.Contains(t.Condition, column) {
+					continue
+				}
+				if fullScanAccessTypes[t.AccessType] || len(t.PossibleKeys) == 0 {
+					unindexed = append(unindexed, attr)
+					break plans
+				}
+			}
+		}
+	}
+	return unindexed
+}
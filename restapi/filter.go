@@ -0,0 +1,226 @@
+package restapi
+
+import (
+	"fmt"
+	"github.com/wangxb07/sqlcomposer"
+	"gitlab.com/beehplus/sql-compose/sqlident"
+	"regexp"
+)
+
+const (
+	maxFilterDepth = 8
+	maxFilterNodes = 64
+)
+
+var filterOperators = map[sqlcomposer.Operator]bool{
+	sqlcomposer.Equal:          true,
+	sqlcomposer.NotEqual:       true,
+	sqlcomposer.Greater:        true,
+	sqlcomposer.Less:           true,
+	sqlcomposer.GreaterOrEqual: true,
+	sqlcomposer.LessOrEqual:    true,
+	sqlcomposer.StartsWith:     true,
+	sqlcomposer.Contains:       true,
+	sqlcomposer.EndsWith:       true,
+	sqlcomposer.In:             true,
+	sqlcomposer.NotIn:          true,
+	sqlcomposer.Between:        true,
+	sqlcomposer.NotBetween:     true,
+	sqlcomposer.IsNull:         true,
+	sqlcomposer.IsNotNull:      true,
+}
+
+// validateFilter rejects filters sqlcomposer would splice into sql unsafely:
+// the attr is written as is and unknown ops are written between attr and
+// value. A missing value is rejected too, sqlx panics expanding IN lists
+// next to a nil arg.
+func validateFilter(attr string, op sqlcomposer.Operator, val interface{}) error {
+	if !sqlident.ValidQualified(attr) {
+		return fmt.Errorf("filter attr %q is invalid", attr)
+	}
+	if !filterOperators[op] {
+		return fmt.Errorf("filter op %q is not supported", op)
+	}
+	if val == nil && op != sqlcomposer.IsNull && op != sqlcomposer.IsNotNull {
+		return fmt.Errorf("filter on %s requires a value", attr)
+	}
+	return nil
+}
+
+// FilterNode is one node of a boolean filter tree. A node is either a leaf
+// condition (attr/op/val) or exactly one of and/or/not.
+type FilterNode struct {
+	And  []*FilterNode        `json:"and,omitempty"`
+	Or   []*FilterNode        `json:"or,omitempty"`
+	Not  *FilterNode          `json:"not,omitempty"`
+	Attr string               `json:"attr,omitempty"`
+	Op   sqlcomposer.Operator `json:"op,omitempty"`
+	Val  interface{}          `json:"val,omitempty"`
+}
+
+func (n *FilterNode) isLeaf() bool {
+	return n.And == nil && n.Or == nil && n.Not == nil
+}
+
+// filterCompiler turns a FilterNode tree into a parenthesized condition
+// statement, expanding filter pipelines declared by the doc on leaves. The
+// args of leaves on masked attrs are marked sensitive.
+//
+// Every leaf gets args of its own, prefixed by its number, so that
+// sqlcomposer.Combine never renames one: it renames by replacing the first
+// occurrence of the placeholder, which also hits longer names sharing the
+// prefix, e.g. :state in :state_date.
+type filterCompiler struct {
+	pipelines map[string]sqlcomposer.FilterPipelineDefinition
+	masked    maskedAttrs
+	nodes     int
+	leaves    int
+}
+
+func compileFilterTree(doc *sqlcomposer.SqlApiDoc, root *FilterNode, masked maskedAttrs) (sqlcomposer.ConditionStmt, error) {
+	fc := &filterCompiler{
+		pipelines: doc.Composition.FilterPipelines,
+		masked:    masked,
+	}
+	return fc.compile(root, 1)
+}
+
+func (fc *filterCompiler) compile(n *FilterNode, depth int) (stmt sqlcomposer.ConditionStmt, err error) {
+	if n == nil {
+		return stmt, fmt.Errorf("empty filter node")
+	}
+	if depth > maxFilterDepth {
+		return stmt, fmt.Errorf("filter tree is deeper than %d levels", maxFilterDepth)
+	}
+	fc.nodes++
+	if fc.nodes > maxFilterNodes {
+		return stmt, fmt.Errorf("filter tree has more than %d nodes", maxFilterNodes)
+	}
+
+	kinds := 0
+	for _, set := range []bool{n.And != nil, n.Or != nil, n.Not != nil, n.Attr != ""} {
+		if set {
+			kinds++
+		}
+	}
+	if kinds != 1 {
+		return stmt, fmt.Errorf("filter node must be exactly one of and, or, not or a condition")
+	}
+
+	switch {
+	case n.And != nil:
+		return fc.group(sqlcomposer.AND, n.And, depth)
+	case n.Or != nil:
+		return fc.group(sqlcomposer.OR, n.Or, depth)
+	case n.Not != nil:
+		inner, err := fc.compile(n.Not, depth+1)
+		if err != nil {
+			return stmt, err
+		}
+		return negate(inner), nil
+	}
+
+	return fc.leaf(n)
+}
+
+func (fc *filterCompiler) group(op sqlcomposer.LogicOperator, children []*FilterNode, depth int) (stmt sqlcomposer.ConditionStmt, err error) {
+	if len(children) == 0 {
+		return stmt, fmt.Errorf("%s group requires at least one filter", op)
+	}
+
+	stmts := make([]sqlcomposer.ConditionStmt, 0, len(children))
+	for _, child := range children {
+		s, err := fc.compile(child, depth+1)
+		if err != nil {
+			return stmt, err
+		}
+		stmts = append(stmts, s)
+	}
+
+	return sqlcomposer.Combine(op, stmts...), nil
+}
+
+func (fc *filterCompiler) leaf(n *FilterNode) (stmt sqlcomposer.ConditionStmt, err error) {
+	stmt, err = fc.condition(n)
+	if err != nil {
+		return stmt, err
+	}
+
+	fc.leaves++
+	stmt = prefixArgs(stmt, fmt.Sprintf("f%d_", fc.leaves))
+	if fc.masked.has(n.Attr) {
+		stmt = markSensitive(stmt)
+	}
+	return stmt, nil
+}
+
+func (fc *filterCompiler) condition(n *FilterNode) (stmt sqlcomposer.ConditionStmt, err error) {
+	if err := validateFilter(n.Attr, n.Op, n.Val); err != nil {
+		return stmt, err
+	}
+
+	f := sqlcomposer.Filter{
+		Attr: n.Attr,
+		Op:   n.Op,
+		Val:  n.Val,
+	}
+
+	if p, ok := fc.pipelines[n.Attr]; ok {
+		gen := sqlcomposer.GenerateExpander(p.Type)
+		if gen == nil {
+			return stmt, fmt.Errorf("%s pipline type not registered", p.Type)
+		}
+		expander := gen(p.Params)
+		if expander == nil {
+			return stmt, fmt.Errorf("%s attr expend failure", n.Attr)
+		}
+		return expander.Expand(f)
+	}
+
+	return sqlcomposer.WhereAnd(&[]sqlcomposer.Filter{f})
+}
+
+var argPlaceholderPattern = regexp.MustCompile(`:(\w+)`)
+
+// prefixArgs renames the args of stmt with prefix, in its clause and clause
+// slices. Every placeholder is matched whole, in a single pass.
+func prefixArgs(stmt sqlcomposer.ConditionStmt, prefix string) sqlcomposer.ConditionStmt {
+	rename := func(clause string) string {
+		return argPlaceholderPattern.ReplaceAllStringFunc(clause, func(placeholder string) string {
+			if _, ok := stmt.Arg[placeholder[1:]]; ok {
+				return ":" + prefix + placeholder[1:]
+			}
+			return placeholder
+		})
+	}
+
+	prefixed := sqlcomposer.ConditionStmt{
+		Clause:      rename(stmt.Clause),
+		Arg:         make(map[string]interface{}, len(stmt.Arg)),
+		ClauseSlice: make(map[string]string, len(stmt.ClauseSlice)),
+	}
+	for k, v := range stmt.Arg {
+		prefixed.Arg[prefix+k] = v
+	}
+	for k, cs := range stmt.ClauseSlice {
+		prefixed.ClauseSlice[k] = rename(cs)
+	}
+	return prefixed
+}
+
+func negate(s sqlcomposer.ConditionStmt) sqlcomposer.ConditionStmt {
+	if s.IsEmpty() {
+		return s
+	}
+
+	slices := map[string]string{}
+	for k, cs := range s.ClauseSlice {
+		slices[k] = fmt.Sprintf("NOT (%s)", cs)
+	}
+
+	return sqlcomposer.ConditionStmt{
+		Clause:      fmt.Sprintf("NOT (%s)", s.Clause),
+		Arg:         s.Arg,
+		ClauseSlice: slices,
+	}
+}
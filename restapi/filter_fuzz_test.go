@@ -0,0 +1,71 @@
+//go:build go1.18
+// +build go1.18
+
+package restapi
+
+import (
+	"encoding/json"
+	"github.com/wangxb07/sqlcomposer"
+	"gitlab.com/beehplus/sql-compose/internal/sqltest"
+	"testing"
+)
+
+func FuzzValidateFilter(f *testing.F) {
+	for _, attr := range append(hostileAttrs, "state", "o.state") {
+		f.Add(attr, string(sqlcomposer.Equal), "x")
+	}
+	for op := range filterOperators {
+		f.Add("state", string(op), "x")
+	}
+	f.Add("state", "= 1 OR 1 =", "x")
+
+	doc := &sqlcomposer.SqlApiDoc{}
+	f.Fuzz(func(t *testing.T, attr string, op string, val string) {
+		if err := validateFilter(attr, sqlcomposer.Operator(op), val); err != nil {
+			return
+		}
+
+		var v interface{} = val
+		switch sqlcomposer.Operator(op) {
+		case sqlcomposer.In, sqlcomposer.NotIn:
+			v = []interface{}{val}
+		case sqlcomposer.Between, sqlcomposer.NotBetween:
+			v = []string{val, val}
+		}
+
+		stmt, err := compileFilterTree(doc, &FilterNode{Attr: attr, Op: sqlcomposer.Operator(op), Val: v}, nil)
+		if err != nil {
+			t.Fatalf("accepted filter %q %q does not compile: %v", attr, op, err)
+		}
+		sqltest.AssertSafeSQL(t, stmt.Clause)
+		assertBinds(t, stmt, boundArgs(sqlcomposer.Operator(op), val, v)...)
+	})
+}
+
+func FuzzCompileFilterTree(f *testing.F) {
+	for _, seed := range []string{
+		`{"attr":"state","op":"=","val":"paid"}`,
+		`{"or":[{"attr":"state","op":"=","val":"a"},{"not":{"attr":"state","op":"=","val":"b"}},{"attr":"state_date","op":">=","val":"c"}]}`,
+		`{"and":[{"attr":"o.id","op":"IN","val":[1,2]},{"attr":"amount","op":"BETWEEN","val":[1,9]}]}`,
+		`{"not":{"attr":"name","op":"CONTAINS","val":"x' OR '1'='1"}}`,
+		`{"attr":"state; DROP TABLE doc","op":"=","val":"x"}`,
+		`{"attr":"state","op":"= 1 OR 1 =","val":"x"}`,
+	} {
+		f.Add([]byte(seed))
+	}
+
+	doc := &sqlcomposer.SqlApiDoc{}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var root FilterNode
+		if err := json.Unmarshal(data, &root); err != nil {
+			return
+		}
+
+		stmt, err := compileFilterTree(doc, &root, nil)
+		if err != nil {
+			return
+		}
+		sqltest.AssertSafeSQL(t, stmt.Clause)
+		assertBinds(t, stmt)
+	})
+}
@@ -0,0 +1,219 @@
+package restapi
+
+import (
+	"github.com/jmoiron/sqlx"
+	"github.com/wangxb07/sqlcomposer"
+	"gitlab.com/beehplus/sql-compose/internal/sqltest"
+	"strings"
+	"testing"
+)
+
+var hostileAttrs = []string{
+	"",
+	"1a",
+	"a b",
+	"a`b",
+	"state; DROP TABLE doc",
+	"state--",
+	"state/**/",
+	"state#",
+	"state'",
+	"a.b.c",
+	"(SELECT 1)",
+	"state=1 OR 1",
+	"ä",
+}
+
+func TestValidateFilter(t *testing.T) {
+	for _, attr := range []string{"state", "o.state", "_x1"} {
+		if err := validateFilter(attr, sqlcomposer.Equal, "x"); err != nil {
+			t.Errorf("validateFilter(%q): %v", attr, err)
+		}
+	}
+
+	for _, attr := range hostileAttrs {
+		if err := validateFilter(attr, sqlcomposer.Equal, "x"); err == nil {
+			t.Errorf("attr %q was accepted", attr)
+		}
+	}
+
+	for _, op := range []sqlcomposer.Operator{"", "= 1 OR 1 =", "; DROP TABLE doc; --", "like", "EQ"} {
+		if err := validateFilter("state", op, "x"); err == nil {
+			t.Errorf("op %q was accepted", op)
+		}
+	}
+
+	if err := validateFilter("state", sqlcomposer.Equal, nil); err == nil {
+		t.Errorf("a filter without a value was accepted")
+	}
+	if err := validateFilter("state", sqlcomposer.IsNull, nil); err != nil {
+		t.Errorf("IS NULL without a value: %v", err)
+	}
+}
+
+func TestCompileFilterTreeBindsValues(t *testing.T) {
+	doc := &sqlcomposer.SqlApiDoc{}
+
+	for op := range filterOperators {
+		for _, v := range sqltest.HostileValues {
+			var val interface{} = v
+			switch op {
+			case sqlcomposer.In, sqlcomposer.NotIn:
+				val = []interface{}{v, "b"}
+			case sqlcomposer.Between, sqlcomposer.NotBetween:
+				val = []string{v, "z"}
+			}
+
+			root := &FilterNode{Or: []*FilterNode{
+				{Not: &FilterNode{Attr: "o.state", Op: op, Val: val}},
+				{And: []*FilterNode{
+					{Attr: "amount", Op: sqlcomposer.GreaterOrEqual, Val: v},
+				}},
+			}}
+
+			stmt, err := compileFilterTree(doc, root, nil)
+			if err != nil {
+				t.Fatalf("op %s with %q: %v", op, v, err)
+			}
+			sqltest.AssertSafeSQL(t, stmt.Clause, v)
+
+			assertBinds(t, stmt, append(boundArgs(op, v, val), v)...)
+		}
+	}
+}
+
+func TestCompileFilterTreeRejectsHostileAttrs(t *testing.T) {
+	doc := &sqlcomposer.SqlApiDoc{}
+
+	for _, attr := range hostileAttrs {
+		root := &FilterNode{And: []*FilterNode{
+			{Attr: "state", Op: sqlcomposer.Equal, Val: "paid"},
+			{Not: &FilterNode{Attr: attr, Op: sqlcomposer.Equal, Val: "x"}},
+		}}
+		if attr == "" {
+			// an empty attr is not a leaf
+			root.And[1].Not.Op = ""
+		}
+
+		if stmt, err := compileFilterTree(doc, root, nil); err == nil {
+			t.Errorf("attr %q was accepted: %q", attr, stmt.Clause)
+		}
+	}
+}
+
+// boundArgs are the args a filter on v binds: as is, in a list or wrapped
+// for LIKE
+func boundArgs(op sqlcomposer.Operator, v string, val interface{}) []interface{} {
+	switch op {
+	case sqlcomposer.IsNull, sqlcomposer.IsNotNull:
+		return nil
+	case sqlcomposer.In, sqlcomposer.NotIn:
+		return val.([]interface{})
+	case sqlcomposer.Between, sqlcomposer.NotBetween:
+		return []interface{}{val.([]string)[0], val.([]string)[1]}
+	case sqlcomposer.StartsWith:
+		return []interface{}{v + "%"}
+	case sqlcomposer.EndsWith:
+		return []interface{}{"%" + v}
+	case sqlcomposer.Contains:
+		return []interface{}{"%" + v + "%"}
+	}
+	return []interface{}{v}
+}
+
+// assertBinds rebinds stmt the way a query is and fails unless its
+// placeholders take want, in order
+func assertBinds(t testing.TB, stmt sqlcomposer.ConditionStmt, want ...interface{}) {
+	t.Helper()
+
+	sql, args, err := sqlx.Named("SELECT * FROM t WHERE "+stmt.Clause, stmt.Arg)
+	if err == nil {
+		sql, args, err = sqlx.In(sql, args...)
+	}
+	if err != nil {
+		t.Fatalf("binding %q failed: %v", stmt.Clause, err)
+	}
+	if strings.Count(sql, "?") != len(args) {
+		t.Fatalf("%q has %d placeholders for %d args", sql, strings.Count(sql, "?"), len(args))
+	}
+	if want == nil {
+		return
+	}
+
+	if len(args) != len(want) {
+		t.Fatalf("%q binds %v, want %v", stmt.Clause, args, want)
+	}
+	for i := range want {
+		if args[i] != want[i] {
+			t.Errorf("%q binds %v at position %d, want %v", stmt.Clause, args[i], i, want[i])
+		}
+	}
+}
+
+func TestCheckMaskedAccess(t *testing.T) {
+	doc := &sqlcomposer.SqlApiDoc{}
+	doc.Composition.Fields = sqlcomposer.SqlCompositionFields{
+		"base": {{Name: "ip", Expr: "ip_address"}, {Name: "state", Expr: "state"}},
+	}
+	masking := map[string]*MaskSpec{"ip": {Rule: maskPartial, Roles: []string{"admin"}}}
+	sorts := SortSpec{Keys: []SortKey{{Name: "addr", Expr: "o.ip_address"}, {Name: "state", Expr: "state"}}}
+
+	for _, req := range []*GetResultRequest{
+		{Filters: []*GetResultFilterItem{{Attr: "ip", Op: sqlcomposer.StartsWith, Val: "10."}}},
+		{Filters: []*GetResultFilterItem{{Attr: "o.ip_address", Op: sqlcomposer.StartsWith, Val: "10."}}},
+		{Where: &FilterNode{Or: []*FilterNode{
+			{Attr: "state", Op: sqlcomposer.Equal, Val: "paid"},
+			{Not: &FilterNode{Attr: "ip_address", Op: sqlcomposer.StartsWith, Val: "10."}},
+		}}},
+		{OrderBy: []*SortItem{{Attr: "addr"}}},
+	} {
+		if err := checkMaskedAccess(maskedAttrsOf(doc, masking, "viewer"), sorts, nil, req); err == nil {
+			t.Errorf("viewer was allowed %+v", req)
+		}
+		if err := checkMaskedAccess(maskedAttrsOf(doc, masking, "admin"), sorts, nil, req); err != nil {
+			t.Errorf("admin was refused: %v", err)
+		}
+	}
+
+	req := &GetResultRequest{
+		Filters: []*GetResultFilterItem{{Attr: "state", Op: sqlcomposer.Equal, Val: "paid"}},
+		OrderBy: []*SortItem{{Attr: "state"}},
+	}
+	if err := checkMaskedAccess(maskedAttrsOf(doc, masking, ""), sorts, nil, req); err != nil {
+		t.Errorf("unmasked attrs were refused: %v", err)
+	}
+}
+
+func TestCompileFilterTreeBindsEachPlaceholder(t *testing.T) {
+	doc := &sqlcomposer.SqlApiDoc{}
+	trees := []*FilterNode{
+		{Or: []*FilterNode{
+			{Attr: "state", Op: sqlcomposer.Equal, Val: "a"},
+			{Not: &FilterNode{Attr: "state", Op: sqlcomposer.Equal, Val: "b"}},
+			{Attr: "state_date", Op: sqlcomposer.GreaterOrEqual, Val: "c"},
+		}},
+		{Or: []*FilterNode{
+			{Attr: "state", Op: sqlcomposer.Equal, Val: "a"},
+			{And: []*FilterNode{
+				{Attr: "state_date", Op: sqlcomposer.GreaterOrEqual, Val: "b"},
+				{Attr: "state", Op: sqlcomposer.Equal, Val: "c"},
+			}},
+		}},
+		{And: []*FilterNode{
+			{Attr: "state", Op: sqlcomposer.Equal, Val: "a"},
+			{Or: []*FilterNode{
+				{Attr: "state", Op: sqlcomposer.Equal, Val: "b"},
+				{Attr: "state_1", Op: sqlcomposer.Equal, Val: "c"},
+			}},
+		}},
+	}
+
+	for _, root := range trees {
+		stmt, err := compileFilterTree(doc, root, nil)
+		if err != nil {
+			t.Fatalf("compile failed: %v", err)
+		}
+
+		assertBinds(t, stmt, "a", "b", "c")
+	}
+}
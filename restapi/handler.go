@@ -1,8 +1,7 @@
 package restapi
 
 import (
-	"database/sql"
-	"fmt"
+	"context"
 	"github.com/gin-gonic/gin"
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/jmoiron/sqlx"
@@ -10,12 +9,9 @@ import (
 	log "github.com/sirupsen/logrus"
 	"github.com/wangxb07/sqlcomposer"
 	"gitlab.com/beehplus/sql-compose/entity"
+	"gitlab.com/beehplus/sql-compose/token"
 	"gopkg.in/yaml.v2"
 	"net/http"
-	"os"
-	"sort"
-	"strings"
-	"sync"
 	"time"
 )
 
@@ -26,45 +22,139 @@ type Handler interface {
 	UpdateDoc(c *gin.Context)
 	DeleteDoc(c *gin.Context)
 	GetResult(c *gin.Context)
+	Mutate(c *gin.Context)
+	PreviewDoc(c *gin.Context)
+	ExplainDoc(c *gin.Context)
 
 	GetDbConfigList(c *gin.Context)
 	AddDbConfig(c *gin.Context)
 	DeleteDbConfigByUUID(c *gin.Context)
 	UpdateDbConfigByUUID(c *gin.Context)
+	RefreshDictionaries(c *gin.Context)
+
+	GetAuditLogList(c *gin.Context)
+	ExportAuditLog(c *gin.Context)
+
+	GetSlowestDocs(c *gin.Context)
+	GetSlowQueryList(c *gin.Context)
+
+	Healthz(c *gin.Context)
+	Readyz(c *gin.Context)
+	GetDatabaseStatus(c *gin.Context)
+}
+
+// Config holds the deployment settings of the handlers
+type Config struct {
+	// Paths of docs that may run statements other than SELECT, outside of a
+	// read only transaction
+	WritableDocs []string
+	// Secret verifying the HS256 bearer tokens of callers
+	JWTSecret string
+	// Requests a second allowed per client, 0 disables the rate limit
+	Rate  float64
+	Burst int
+	// Max queries running at once per doc and per database config, 0 is
+	// unlimited. Docs may override DocConcurrency in composition.limits.
+	DocConcurrency int
+	DbConcurrency  int
+	// Prefix of the doc paths, docs may override CORS in composition.cors
+	BasePath   string
+	CORS       CORSPolicy
+	HSTSMaxAge time.Duration
+	// Key of the hash masking rule
+	MaskKey string
+	// Roles of the authenticated callers allowed to preview unsaved docs,
+	// none disables the preview
+	PreviewRoles []string
+	// Queries running at least SlowQuery are logged, 0 disables the log.
+	// Query stats cover the last StatsWindow, an hour by default.
+	SlowQuery   time.Duration
+	StatsWindow time.Duration
+	// Timeout of each check of the readiness and database status
+	HealthTimeout time.Duration
+	// Max run time of a mutation, 5 minutes by default. A pending execution
+	// log older than this is taken over by a retry with the same key.
+	MutationTimeout time.Duration
+}
+
+func (conf Config) previewer(caller *Caller) bool {
+	if caller.Anonymous() {
+		return false
+	}
+	for _, role := range conf.PreviewRoles {
+		if role == caller.Role {
+			return true
+		}
+	}
+	return false
+}
+
+func (conf Config) writable(path string) bool {
+	for _, p := range conf.WritableDocs {
+		if p == path {
+			return true
+		}
+	}
+	return false
 }
 
 type Service struct {
-	Db *sqlx.DB
+	Db     *sqlx.DB
+	conf   Config
+	pool   *dbPool
+	tokens *token.Registry
+	limits *limits
+	stats  *queryStats
+	cors   *corsCache
+
+	// slowQueries are written to the slow query log by PersistStats
+	slowQueries chan *entity.SlowQuery
+
+	// queries is done once the queries in flight are cancelled on shutdown
+	queries       context.Context
+	cancelQueries context.CancelFunc
+	draining      int32
+	requests      *requestCounter
 }
 
-func NewHandler(db *sqlx.DB) *Service {
+func NewHandler(db *sqlx.DB, tokens *token.Registry, conf Config) *Service {
+	queries, cancelQueries := context.WithCancel(context.Background())
 	return &Service{
-		Db: db,
+		Db:            db,
+		conf:          conf,
+		pool:          newDbPool(),
+		tokens:        tokens,
+		limits:        newLimits(conf),
+		stats:         newQueryStats(conf.StatsWindow),
+		cors:          newCORSCache(corsCacheTTL),
+		slowQueries:   make(chan *entity.SlowQuery, slowQueryQueue),
+		queries:       queries,
+		cancelQueries: cancelQueries,
+		requests:      newRequestCounter(),
 	}
 }
 
-// @Summary 删除文档
-// @Tags 文档
-// @version 1.0
-// @Param uuid path string true "uuid"
-// @Success 201 {string} string	""delete completed""
-// @Failure 400 {object} Error "error"
-// @Router /doc/{uuid} [delete]
 func (s *Service) DeleteDoc(c *gin.Context) {
 	uuid := c.Param("uuid")
-	fmt.Println(uuid)
-	s.Db.MustExec("DELETE FROM doc WHERE uuid=?", uuid)
+	tx := s.Db.MustBegin()
+	before := s.snapshot(tx, auditTargetDoc, uuid)
+	if _, err := tx.Exec("DELETE FROM doc WHERE uuid=?", uuid); err != nil {
+		log.Error(err)
+		tx.Rollback()
+		c.JSON(http.StatusBadRequest, Error{
+			Code:    40004,
+			Message: "delete failed",
+		})
+		return
+	}
+	if !s.commitAudited(tx, c, "doc.delete", auditTargetDoc, uuid, before) {
+		return
+	}
+	s.forgetExplain(uuid)
+	s.cors.invalidate()
 	c.String(http.StatusCreated, "successfully deleted")
 }
 
-// @Summary 添加新的文档
-// @Tags 文档
-// @version 1.0
-// @Param content formData string true "文档内容"
-// @Param path formData string true "接口路径"
-// @Success 201 {string} string	""insert completed""
-// @Failure 400 {object} Error "deserialize yaml failed"
-// @Router /doc [patch]
 func (s *Service) AddDoc(c *gin.Context) {
 	content := c.PostForm("content")
 	path := c.PostForm("path")
@@ -81,6 +171,18 @@ func (s *Service) AddDoc(c *gin.Context) {
 		return
 	}
 
+	spec, err := parseDocSpec(buffer)
+	if err == nil {
+		err = checkDocStatements(&doc, spec, s.conf.writable(path))
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Error{
+			Code:    40017,
+			Message: err.Error(),
+		})
+		return
+	}
+
 	uuid1 := uuid.NewV4().String()
 	params := map[string]interface{}{
 		"name":       doc.Info.Name,
@@ -94,30 +196,26 @@ func (s *Service) AddDoc(c *gin.Context) {
 	////todo sqlx判断记录为空有更好的方法
 	//c.String(http.StatusBadRequest, "the document does not exist")
 
-	_, err = s.Db.NamedExec(`INSERT into doc (name,path,content,created_at,updated_at,uuid) VALUES (:name,:path,:content,:created_at,:updated_at,:uuid)`,
+	tx := s.Db.MustBegin()
+	_, err = tx.NamedExec(`INSERT into doc (name,path,content,created_at,updated_at,uuid) VALUES (:name,:path,:content,:created_at,:updated_at,:uuid)`,
 		params,
 	)
 	if err != nil {
 		log.Warn(err)
+		tx.Rollback()
 		c.JSON(http.StatusBadRequest, Error{
 			Code:    40001,
 			Message: "insert failed,maybe the name is duplicated",
 		})
 		return
 	}
+	if !s.commitAudited(tx, c, "doc.create", auditTargetDoc, uuid1, nil) {
+		return
+	}
+	s.cors.invalidate()
 	c.String(http.StatusCreated, uuid1)
 }
 
-// @Summary 添加新的文档基本信息，不包含文档内容
-// @Tags 文档
-// @version 1.0
-// @Param name formData string true "文档名称"
-// @Param path formData string true "接口路径"
-// @Param description formData string true "文档描述"
-// @Param db_name formData string true "数据库名称"
-// @Success 201 {string} string	""insert completed""
-// @Failure 400 {object} Error "deserialize yaml failed"
-// @Router /doc [post]
 func (s *Service) PostDoc(c *gin.Context) {
 	name := c.PostForm("name")
 	path := c.PostForm("path")
@@ -135,12 +233,14 @@ func (s *Service) PostDoc(c *gin.Context) {
 		"uuid":        id,
 	}
 
-	_, err := s.Db.NamedExec(`INSERT into doc (name, path, description, db_name, created_at, updated_at, uuid) VALUES (:name,:path,:description,:db_name,:created_at,:updated_at,:uuid)`,
+	tx := s.Db.MustBegin()
+	_, err := tx.NamedExec(`INSERT into doc (name, path, description, db_name, created_at, updated_at, uuid) VALUES (:name,:path,:description,:db_name,:created_at,:updated_at,:uuid)`,
 		params,
 	)
 
 	if err != nil {
 		log.Warn(err)
+		tx.Rollback()
 		c.JSON(http.StatusBadRequest, Error{
 			Code:    40001,
 			Message: "insert failed,maybe the name is duplicated",
@@ -148,15 +248,12 @@ func (s *Service) PostDoc(c *gin.Context) {
 		return
 	}
 
+	if !s.commitAudited(tx, c, "doc.create", auditTargetDoc, id, nil) {
+		return
+	}
 	c.String(http.StatusCreated, id)
 }
 
-// @Summary 获取文档列表
-// @Uuid xxx123
-// @Tags 文档
-// @version 1.0
-// @Success 200 {object} DocListResult
-// @Router /doc [get]
 func (s *Service) GetDocList(c *gin.Context) {
 	var result DocListResult
 	result.Data = []*entity.Doc{}
@@ -175,12 +272,6 @@ func (s *Service) GetDocList(c *gin.Context) {
 
 }
 
-// @Summary 获取文档详情
-// @Tags 文档
-// @version 1.0
-// @Param uuid path string true "uuid"
-// @Success 200 {object} entity.Doc
-// @Router /doc/{uuid} [get]
 func (s *Service) GetDocDetailByUuid(c *gin.Context) {
 
 	var doc entity.Doc
@@ -190,20 +281,9 @@ func (s *Service) GetDocDetailByUuid(c *gin.Context) {
 		c.JSON(http.StatusNotFound, nil)
 		return
 	}
-	c.JSON(http.StatusOK, doc)
+	c.JSON(http.StatusOK, s.docDetail(doc))
 }
 
-// @Summary 更新文档
-// @Tags 文档
-// @version 1.0
-// @Param uuid path string true "uuid"
-// @Param content formData string true "content"
-// @Param description formData string true "description"
-// @Param db_name formData string true "db_name"
-// @Param path formData string true "path"
-// @Success 201 {string} string	"update completed"
-// @Failure 400 {object} Error "error"
-// @Router /doc/{uuid} [post]
 func (s *Service) UpdateDoc(c *gin.Context) {
 	var docEntity entity.Doc
 
@@ -226,6 +306,18 @@ func (s *Service) UpdateDoc(c *gin.Context) {
 		return
 	}
 
+	spec, err := parseDocSpec(buffer)
+	if err == nil {
+		err = checkDocStatements(&doc, spec, s.conf.writable(path))
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Error{
+			Code:    40017,
+			Message: err.Error(),
+		})
+		return
+	}
+
 	params := map[string]interface{}{
 		"uuid":        c.Param("uuid"),
 		"name":        name,
@@ -237,9 +329,11 @@ func (s *Service) UpdateDoc(c *gin.Context) {
 	}
 
 	tx := s.Db.MustBegin()
+	before := s.snapshot(tx, auditTargetDoc, c.Param("uuid"))
 	err = tx.Get(&docEntity, "SELECT uuid from doc where uuid=?", c.Param("uuid"))
 
 	if err != nil {
+		tx.Rollback()
 		c.JSON(http.StatusBadRequest, Error{
 			Code:    40003,
 			Message: "This document does not exist",
@@ -259,20 +353,15 @@ func (s *Service) UpdateDoc(c *gin.Context) {
 		})
 		return
 	}
-	tx.Commit()
+	if !s.commitAudited(tx, c, "doc.update", auditTargetDoc, c.Param("uuid"), before) {
+		return
+	}
+	s.forgetExplain(c.Param("uuid"))
+	s.cors.invalidate()
 
 	c.String(http.StatusCreated, "update completed")
 }
 
-// @Summary 获取查询结果
-// @Tags 接口
-// @version 1.0
-// @Param path path string true "path"
-// @Param debug query string true "debug"
-// @Success 200 {string} string	"json"
-// @Failure 400 {object} Error "error"
-// @Failure 404 {object} Error "not found"
-// @Router /{path} [get]
 func (s *Service) GetResult(c *gin.Context) {
 	//get yml by path from db
 	path := c.Param("path")
@@ -290,17 +379,44 @@ func (s *Service) GetResult(c *gin.Context) {
 
 	//get filter params
 	var req GetResultRequest
-	if err := c.BindJSON(&req); err != nil {
+	if err := bindResultRequest(c, []byte(*docEntity.Content), &req); err != nil {
 		log.Error(err)
 		c.JSON(http.StatusBadRequest, Error{
 			Code:    40006,
-			Message: "filter params error",
+			Message: "filter params error: " + err.Error(),
 		})
 		return
 	}
 
+	s.serveResult(c, path, &docEntity, &req, debug == "1", resultRows)
+}
+
+type resultMode int
+
+const (
+	// resultRows answers the rows of the doc
+	resultRows resultMode = iota
+	// resultPreview answers the rendered sql, args and plans next to the
+	// first rows
+	resultPreview
+	// resultExplain answers the analysis of the plans, without running the
+	// queries
+	resultExplain
+)
+
+// serveResult runs the query doc for req and answers it as mode asks
+func (s *Service) serveResult(c *gin.Context, path string, docEntity *entity.Doc, req *GetResultRequest, debug bool, mode resultMode) {
 	var custFilters []sqlcomposer.Filter
 	for _, filter := range req.Filters {
+		if err := validateFilter(filter.Attr, filter.Op, filter.Val); err != nil {
+			log.Error(err)
+			c.JSON(http.StatusBadRequest, Error{
+				Code:    40006,
+				Message: err.Error(),
+			})
+			return
+		}
+
 		custFilter := sqlcomposer.Filter{
 			Val:  filter.Val,
 			Op:   filter.Op,
@@ -321,6 +437,34 @@ func (s *Service) GetResult(c *gin.Context) {
 			Code:    40007,
 			Message: err.Error(),
 		})
+		return
+	}
+
+	spec, err := parseDocSpec(buffer)
+	if err != nil {
+		log.Warn(err)
+		c.JSON(http.StatusBadRequest, Error{
+			Code:    40007,
+			Message: err.Error(),
+		})
+		return
+	}
+	if spec.isMutation() {
+		c.JSON(http.StatusBadRequest, Error{
+			Code:    40019,
+			Message: "this doc is a mutation, call it with PUT",
+		})
+		return
+	}
+
+	masked := maskedAttrsOf(&doc, spec.Composition.Masking, callerOf(c).Role)
+	if err := checkMaskedAccess(masked, spec.Composition.Sorts, spec.Composition.Aggregates, req); err != nil {
+		log.Warn(err)
+		c.JSON(http.StatusForbidden, Error{
+			Code:    40302,
+			Message: err.Error(),
+		})
+		return
 	}
 
 	var dbConfig entity.DataBaseConfig
@@ -334,9 +478,13 @@ func (s *Service) GetResult(c *gin.Context) {
 		return
 	}
 
-	dsn := dbConfig.Dsn
+	release, ok := s.acquireQuery(c, path, spec, dbConfig.Name)
+	if !ok {
+		return
+	}
+	defer release()
 
-	db, err := sqlx.Connect("mysql", dsn)
+	db, releaseDb, err := s.pool.GetContext(c.Request.Context(), dbConfig.Name, dbConfig.Dsn)
 	if err != nil {
 		log.Error(err)
 		c.JSON(http.StatusBadRequest, Error{
@@ -345,7 +493,7 @@ func (s *Service) GetResult(c *gin.Context) {
 		})
 		return
 	}
-	defer db.Close()
+	defer releaseDb()
 
 	sqlBuilder, err := sqlcomposer.NewSqlBuilder(db, []byte(*docEntity.Content))
 	if err != nil {
@@ -356,205 +504,287 @@ func (s *Service) GetResult(c *gin.Context) {
 		})
 		return
 	}
-	configureSqlCompose(sqlBuilder)
+	tokens := s.tokens.Configure(sqlBuilder, dbConfig.Name)
+	for name, eav := range spec.Composition.EAV {
+		if err := tokens.AddEAV(name, eav); err != nil {
+			log.Error(err)
+			c.JSON(http.StatusBadRequest, Error{
+				Code:    40010,
+				Message: err.Error(),
+			})
+			return
+		}
+	}
 
-	result := struct {
-		Total int64             `json:"total,omitempty"`
-		Data  []interface{}     `json:"data,omitempty"`
-		SQL   map[string]string `json:"sql"`
-	}{}
+	// row level security goes first, the client filters can only narrow it
+	if spec.Composition.Security != nil {
+		rls, err := securityConditions(spec.Composition.Security, callerOf(c))
+		if err != nil {
+			log.Warn(err)
+			c.JSON(http.StatusForbidden, Error{
+				Code:    40301,
+				Message: err.Error(),
+			})
+			return
+		}
+		rls = markSensitive(rls)
+		sqlBuilder.AndConditions(&rls)
+	}
 
-	result.SQL = make(map[string]string)
-	for key := range doc.Composition.Subject {
-		err = sqlBuilder.AddFilters(custFilters, sqlcomposer.AND)
+	// the values of filters on masked fields are kept out of the logs, even
+	// for callers allowed to see the fields
+	sensitive := maskedAttrsOf(&doc, spec.Composition.Masking, "")
+	if req.Where != nil {
+		where, err := compileFilterTree(sqlBuilder.Doc, req.Where, sensitive)
 		if err != nil {
 			log.Error(err)
+			c.JSON(http.StatusBadRequest, Error{
+				Code:    40011,
+				Message: err.Error(),
+			})
+			return
 		}
+		sqlBuilder.AndConditions(&where)
+	}
 
-		q, a, err := sqlBuilder.Limit((req.PageIndex-1)*req.PageLimit, req.PageLimit).Rebind(key)
-
-		if debug == "1" {
-			result.SQL[key] = q
+	sorts := spec.Composition.Sorts
+	if req.Aggregate != nil {
+		if req.PageMode == cursorPageMode || req.Cursor != "" || len(req.Fields) > 0 {
+			c.JSON(http.StatusBadRequest, Error{
+				Code:    40015,
+				Message: "aggregate can not be combined with cursor or fields",
+			})
+			return
 		}
 
+		subject, aggregateSorts, err := buildAggregate(spec.Composition.Aggregates, req.Aggregate)
 		if err != nil {
 			log.Error(err)
-			c.JSON(http.StatusBadRequest, err)
+			c.JSON(http.StatusBadRequest, Error{
+				Code:    40015,
+				Message: err.Error(),
+			})
 			return
 		}
+		sqlBuilder.Doc.Composition.Subject = subject
+		sorts = aggregateSorts
+	}
 
-		if key == "total" {
-			var total int64
-			err = db.QueryRowx(q, a...).Scan(&total)
-			if err != nil {
-				log.Error(err)
-				c.JSON(http.StatusBadRequest, err)
-				return
-			}
-			result.Total = total
-		} else {
-			rows, err := db.Queryx(q, a...)
-
-			if err != nil {
-				log.Error(err)
-				c.JSON(http.StatusBadRequest, err)
-				return
-			}
-
-			for rows.Next() {
-				item := make(map[string]interface{})
-				err := rows.MapScan(item)
-				if err != nil {
-					log.Error(err)
-				}
-
-				for k, encoded := range item {
-					switch encoded.(type) {
-					case []byte:
-						item[k] = string(encoded.([]byte))
-					}
-				}
-
-				result.Data = append(result.Data, item)
-			}
+	var cursor *cursorPage
+	offset, size := (req.PageIndex-1)*req.PageLimit, req.PageLimit
+	if req.PageMode == cursorPageMode || req.Cursor != "" {
+		if len(req.OrderBy) > 0 {
+			c.JSON(http.StatusBadRequest, Error{
+				Code:    40013,
+				Message: "order_by is not supported in cursor mode",
+			})
+			return
+		}
 
-			if err != nil {
-				log.Error(err)
-			}
+		cursor, err = newCursorPage(spec.Composition.Cursor, req.Cursor, req.PageLimit)
+		if err != nil {
+			log.Error(err)
+			c.JSON(http.StatusBadRequest, Error{
+				Code:    40013,
+				Message: err.Error(),
+			})
+			return
 		}
 
+		seek := cursor.condition()
+		sqlBuilder.AndConditions(&seek)
+		orderBy := cursor.orderBy()
+		sqlBuilder.OrderBy(&orderBy)
+		offset, size = 0, cursor.fetchSize()
+	} else {
+		orderBy, err := buildOrderBy(sorts, req.OrderBy)
+		if err != nil {
+			log.Error(err)
+			c.JSON(http.StatusBadRequest, Error{
+				Code:    40012,
+				Message: err.Error(),
+			})
+			return
+		}
+		if orderBy != nil {
+			sqlBuilder.OrderBy(&orderBy)
+		}
 	}
 
-	c.JSON(http.StatusOK, result)
-}
-
-type attrsTokenReplacer struct {
-	Attrs map[string]string
-	DB    *sqlx.DB
-}
+	var keep []string
+	if cursor != nil {
+		keep = append(keep, cursor.spec.Attr)
+	}
+	if err := projectFields(sqlBuilder.Doc, req.Fields, keep...); err != nil {
+		log.Error(err)
+		c.JSON(http.StatusBadRequest, Error{
+			Code:    40014,
+			Message: err.Error(),
+		})
+		return
+	}
 
-func (atr *attrsTokenReplacer) TokenReplace(ctx map[string]interface{}) string {
-	return ProductAttrsToJoinInStat(atr.DB, atr.Attrs)
-}
+	var result GetResultResponse
 
-type attrsFieldsTokenReplacer struct {
-	Attrs map[string]string
-}
+	// filters on masked fields are compiled as a tree to mark their args
+	var plainFilters []sqlcomposer.Filter
+	var maskedFilters []*FilterNode
+	for _, f := range custFilters {
+		if sensitive.has(f.Attr) {
+			maskedFilters = append(maskedFilters, &FilterNode{Attr: f.Attr, Op: f.Op, Val: f.Val})
+		} else {
+			plainFilters = append(plainFilters, f)
+		}
+	}
 
-func (atr *attrsFieldsTokenReplacer) TokenReplace(ctx map[string]interface{}) string {
-	return ProductAttrsToSelect(atr.Attrs)
-}
+	err = sqlBuilder.AddFilters(plainFilters, sqlcomposer.AND)
+	if err == nil && len(maskedFilters) > 0 {
+		var masked sqlcomposer.ConditionStmt
+		if masked, err = compileFilterTree(sqlBuilder.Doc, &FilterNode{And: maskedFilters}, sensitive); err == nil {
+			sqlBuilder.AndConditions(&masked)
+		}
+	}
+	if err != nil {
+		log.Error(err)
+		c.JSON(http.StatusBadRequest, Error{
+			Code:    40006,
+			Message: "filter params error: " + err.Error(),
+		})
+		return
+	}
 
-var once sync.Once
+	if err := tokens.Prepare(); err != nil {
+		log.Error(err)
+		c.JSON(http.StatusBadRequest, Error{
+			Code:    40016,
+			Message: err.Error(),
+		})
+		return
+	}
 
-type DictTypesSingleton map[string]string
+	if err := bindDocParams(sqlBuilder, spec.Composition.Params, req.Params); err != nil {
+		log.Error(err)
+		c.JSON(http.StatusBadRequest, Error{
+			Code:    40021,
+			Message: err.Error(),
+		})
+		return
+	}
 
-var dictTypes DictTypesSingleton
+	if spec.Composition.Security != nil {
+		if err := checkSecuredSubject(sqlBuilder.Doc.Composition.Subject); err != nil {
+			log.Error(err)
+			c.JSON(http.StatusBadRequest, Error{
+				Code:    40017,
+				Message: err.Error(),
+			})
+			return
+		}
+	}
 
-type DictType struct {
-	SID         string         `db:"sid"`
-	Code        string         `db:"code"`
-	Name        string         `db:"name"`
-	Status      int            `db:"status"`
-	Description string         `db:"description"`
-	CreateTime  sql.NullString `db:"create_time"`
-	UpdateTime  sql.NullString `db:"update_time"`
-	IsDelete    int            `db:"is_delete"`
-	ParentCode  sql.NullString `db:"parent_code"`
-}
+	// the total is not needed to walk pages by cursor
+	withTotal := cursor == nil && (req.WithTotal == nil || *req.WithTotal)
 
-func GetMESDictTypes(db *sqlx.DB) DictTypesSingleton {
-	once.Do(func() {
-		dictTypes = DictTypesSingleton{}
+	readOnly := !s.conf.writable(path)
+	var queries []compositionQuery
+	result.SQL = make(map[string]string)
+	for _, key := range compositionKeys(sqlBuilder.Doc.Composition.Subject, withTotal) {
+		q, a, err := sqlBuilder.Limit(offset, size).Rebind(key)
 
-		var types []DictType
-		types = []DictType{}
-		err := db.Select(&types, "SELECT * FROM fty_dictionary_type")
+		if debug || mode != resultRows {
+			result.SQL[key] = q
+		}
 
 		if err != nil {
 			log.Error(err)
+			c.JSON(http.StatusBadRequest, err)
+			return
 		}
 
-		for _, value := range types {
-			dictTypes[value.Code] = value.SID
+		if readOnly {
+			if err := checkReadOnly(q); err != nil {
+				log.Error(err)
+				c.JSON(http.StatusBadRequest, Error{
+					Code:    40017,
+					Message: err.Error(),
+				})
+				return
+			}
 		}
-	})
 
-	return dictTypes
-}
+		positions, err := sensitivePositions(sqlBuilder, key)
+		if err != nil {
+			log.Error(err)
+			c.JSON(http.StatusBadRequest, err)
+			return
+		}
 
-func ProductAttrsToJoinInStat(db *sqlx.DB, a map[string]string) string {
-	var str []string
+		queries = append(queries, compositionQuery{Key: key, Query: q, Args: a, Sensitive: positions})
+	}
 
-	dt := GetMESDictTypes(db)
+	if mode == resultExplain {
+		s.serveExplain(c, db, docEntity, req, queries, result.SQL)
+		return
+	}
 
-	for key, _ := range a {
-		alias := strings.Replace(key, "-", "_", -1)
+	ctx, cancel := s.queryContext(c.Request.Context())
+	defer cancel()
 
-		sid, ok := dt[key]
+	results, err := runCompositions(ctx, db, queries, readOnly)
+	if mode == resultRows {
+		s.recordQueries(path, queries, results)
+	}
+	if err != nil {
+		log.Error(err)
+		c.JSON(http.StatusBadRequest, err)
+		return
+	}
 
-		if !ok {
-			os.Exit(500)
+	for _, res := range results {
+		if res.Key == "total" {
+			result.Total = res.Total
+		} else {
+			result.Data = append(result.Data, res.Rows...)
 		}
+	}
 
-		str = append(str,
-			fmt.Sprintf(`LEFT JOIN fty_obj_attr AS %s ON %s.attr_sid = '%s' AND %s.obj_sid = fty_product.sid`,
-				alias, alias, sid, alias))
+	if cursor != nil {
+		result.Data, result.NextCursor, err = cursor.paginate(result.Data)
+		if err != nil {
+			log.Error(err)
+			c.JSON(http.StatusBadRequest, Error{
+				Code:    40013,
+				Message: err.Error(),
+			})
+			return
+		}
 	}
-	sort.Strings(str)
-	return strings.Join(str, " ")
-}
 
-func ProductAttrsToSelect(a map[string]string) string {
-	var str []string
-	for key, value := range a {
-		alias := strings.Replace(key, "-", "_", -1)
-		str = append(str, fmt.Sprintf("%s.attr_value AS %s", alias, value))
+	maskRows(result.Data, spec.Composition.Masking, callerOf(c).Role, []byte(s.conf.MaskKey))
+
+	if mode == resultPreview {
+		s.servePreview(c, db, queries, &result)
+		return
 	}
-	sort.Strings(str)
-	return strings.Join(str, ",")
-}
 
-func configureSqlCompose(sb *sqlcomposer.SqlBuilder) {
-	sb.RegisterToken("attrs", func(params []sqlcomposer.TokenParam) sqlcomposer.TokenReplacer {
-		attrs := map[string]string{}
-		for _, p := range params {
-			attrs[p.Name] = p.Value
-		}
-		return &attrsTokenReplacer{
-			Attrs: attrs,
-			DB:    sb.DB,
-		}
-	})
+	c.JSON(http.StatusOK, result)
+}
 
-	sb.RegisterToken("attrs_fields", func(params []sqlcomposer.TokenParam) sqlcomposer.TokenReplacer {
-		attrs := map[string]string{}
-		for _, p := range params {
-			attrs[p.Name] = p.Value
-		}
-		return &attrsFieldsTokenReplacer{
-			Attrs: attrs,
-		}
-	})
+func (s *Service) RefreshDictionaries(c *gin.Context) {
+	s.tokens.Dictionaries.Refresh(c.Query("db_name"))
+	c.String(http.StatusOK, "refresh completed")
 }
 
-// @Summary 添加数据库配置
-// @Tags 数据库配置
-// @version 1.0
-// @Param name formData string true "name"
-// @Param dns formData string true "dns"
-// @Success 201 {string} string	"json"
-// @Failure 400 {object} Error "error"
-// @Router /dbconfig [patch]
 func (s *Service) AddDbConfig(c *gin.Context) {
 	name := c.PostForm("name")
 	dns := c.PostForm("dns")
 
+	id := uuid.NewV4().String()
 	tx := s.Db.MustBegin()
 
 	_, err := tx.NamedExec("INSERT INTO database_config (uuid,name,dsn,created_at,updated_at) VALUES (:uuid,:name,:dsn,:created_at,:updated_at)",
 		map[string]interface{}{
-			"uuid":       uuid.NewV4().String(),
+			"uuid":       id,
 			"name":       name,
 			"dsn":        dns,
 			"created_at": time.Now().Unix(),
@@ -569,33 +799,35 @@ func (s *Service) AddDbConfig(c *gin.Context) {
 		tx.Rollback()
 		return
 	}
-	tx.Commit()
 
+	if !s.commitAudited(tx, c, "dbconfig.create", auditTargetDbConfig, id, nil) {
+		return
+	}
 	c.String(http.StatusCreated, "added successfully")
 }
 
-// @Summary 删除数据库配置
-// @Tags 数据库配置
-// @version 1.0
-// @Param uuid path string true "uuid"
-// @Success 201 {string} string	"json"
-// @Failure 400 {object} Error "error"
-// @Router /dbconfig/{uuid} [delete]
 func (s *Service) DeleteDbConfigByUUID(c *gin.Context) {
 	uuid := c.Param("uuid")
-	s.Db.MustExec("DELETE FROM database_config WHERE uuid=?", uuid)
+	name := s.dbConfigName(uuid)
+	tx := s.Db.MustBegin()
+	before := s.snapshot(tx, auditTargetDbConfig, uuid)
+	if _, err := tx.Exec("DELETE FROM database_config WHERE uuid=?", uuid); err != nil {
+		log.Error(err)
+		tx.Rollback()
+		c.JSON(http.StatusBadRequest, Error{
+			Code:    40004,
+			Message: "delete failed",
+		})
+		return
+	}
+	if !s.commitAudited(tx, c, "dbconfig.delete", auditTargetDbConfig, uuid, before) {
+		return
+	}
+	s.pool.Close(name)
 
 	c.String(http.StatusCreated, "successfully deleted")
 }
 
-// @Summary 更新数据库配置
-// @Tags 数据库配置
-// @version 1.0
-// @Param params body AddDbConfigRequest true "DbConfig"
-// @Param uuid path string true "uuid"
-// @Success 201 {string} string	"更新成功"
-// @Failure 400 {object} Error "error"
-// @Router /dbconfig/{uuid} [post]
 func (s *Service) UpdateDbConfigByUUID(c *gin.Context) {
 	var req UpdateDbConfigRequest
 	if err := c.Bind(&req); err != nil {
@@ -606,7 +838,10 @@ func (s *Service) UpdateDbConfigByUUID(c *gin.Context) {
 		})
 		return
 	}
-	_, err := s.Db.NamedExec("UPDATE database_config SET name=:name,dsn=:dsn,updated_at=:updated_at WHERE uuid=:uuid",
+	name := s.dbConfigName(c.Param("uuid"))
+	tx := s.Db.MustBegin()
+	before := s.snapshot(tx, auditTargetDbConfig, c.Param("uuid"))
+	_, err := tx.NamedExec("UPDATE database_config SET name=:name,dsn=:dsn,updated_at=:updated_at WHERE uuid=:uuid",
 		map[string]interface{}{
 			"name":       req.Name,
 			"dsn":        req.Dsn,
@@ -615,15 +850,20 @@ func (s *Service) UpdateDbConfigByUUID(c *gin.Context) {
 		})
 	if err != nil {
 		log.Error(err)
+		tx.Rollback()
+		c.JSON(http.StatusBadRequest, Error{
+			Code:    40004,
+			Message: "update failed",
+		})
+		return
+	}
+	if !s.commitAudited(tx, c, "dbconfig.update", auditTargetDbConfig, c.Param("uuid"), before) {
+		return
 	}
+	s.pool.Close(name)
 	c.String(http.StatusOK, "update completed")
 }
 
-// @Summary 数据库配置列表
-// @Tags 数据库配置
-// @version 1.0
-// @Success 200 {object} DbConfigList
-// @Router /dbconfig [get]
 func (s *Service) GetDbConfigList(c *gin.Context) {
 	var list DbConfigList
 	list.Data = []*entity.DataBaseConfig{}
@@ -643,3 +883,14 @@ func (s *Service) GetDbConfigList(c *gin.Context) {
 
 	c.JSON(http.StatusOK, list)
 }
+
+// dbConfigName is the name the pool of a config is kept under. The pool is
+// closed after the config changed, requests still using it keep it until
+// they return.
+func (s *Service) dbConfigName(uuid string) string {
+	var name string
+	if err := s.Db.Get(&name, "SELECT name FROM database_config WHERE uuid=?", uuid); err != nil {
+		log.Warn(err)
+	}
+	return name
+}
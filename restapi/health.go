@@ -0,0 +1,122 @@
+package restapi
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"gitlab.com/beehplus/sql-compose/entity"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultHealthTimeout = 2 * time.Second
+
+func (s *Service) healthTimeout() time.Duration {
+	if s.conf.HealthTimeout > 0 {
+		return s.conf.HealthTimeout
+	}
+	return defaultHealthTimeout
+}
+
+// Healthz answers while the process serves requests
+func (s *Service) Healthz(c *gin.Context) {
+	c.JSON(http.StatusOK, HealthStatus{Status: "ok"})
+}
+
+// Readyz answers 503 until the metadata database answers and the docs and
+// the token registry can be loaded, and again once the service drains
+func (s *Service) Readyz(c *gin.Context) {
+	if s.isDraining() {
+		c.JSON(http.StatusServiceUnavailable, HealthStatus{Status: "draining"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), s.healthTimeout())
+	defer cancel()
+
+	status := HealthStatus{Status: "ok", Checks: map[string]string{}}
+	check := func(name string, err error) {
+		if err != nil {
+			log.Warn(err)
+			status.Status = "unavailable"
+			status.Checks[name] = err.Error()
+			return
+		}
+		status.Checks[name] = "ok"
+	}
+
+	check("metadata_db", s.Db.PingContext(ctx))
+
+	var docs int64
+	check("doc_registry", s.Db.GetContext(ctx, &docs, "SELECT COUNT(id) FROM doc"))
+
+	if s.tokens == nil {
+		status.Status = "unavailable"
+		status.Checks["token_registry"] = "not loaded"
+	} else {
+		status.Checks["token_registry"] = "ok"
+	}
+
+	if status.Status != "ok" {
+		c.JSON(http.StatusServiceUnavailable, status)
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+// GetDatabaseStatus pings every database config at once, each within the
+// health timeout. The DSNs are never part of the answer.
+func (s *Service) GetDatabaseStatus(c *gin.Context) {
+	var configs []*entity.DataBaseConfig
+	if err := s.Db.SelectContext(c.Request.Context(), &configs, "SELECT * FROM database_config ORDER BY name"); err != nil {
+		log.Error(err)
+		c.JSON(http.StatusServiceUnavailable, Error{
+			Code:    50301,
+			Message: "metadata database unavailable",
+		})
+		return
+	}
+
+	list := DatabaseStatusList{
+		Data:  make([]*DatabaseStatus, len(configs)),
+		Total: int64(len(configs)),
+	}
+
+	var wg sync.WaitGroup
+	for i, conf := range configs {
+		wg.Add(1)
+		go func(i int, conf *entity.DataBaseConfig) {
+			defer wg.Done()
+			list.Data[i] = s.pingDatabase(c.Request.Context(), conf)
+		}(i, conf)
+	}
+	wg.Wait()
+
+	for _, status := range list.Data {
+		if !status.OK {
+			list.Failed++
+		}
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+func (s *Service) pingDatabase(ctx context.Context, conf *entity.DataBaseConfig) *DatabaseStatus {
+	ctx, cancel := context.WithTimeout(ctx, s.healthTimeout())
+	defer cancel()
+
+	status := &DatabaseStatus{UUID: conf.UUID, Name: conf.Name}
+	start := time.Now()
+	err := pingTarget(ctx, conf.Dsn)
+	status.LatencyMs = durationMs(time.Since(start))
+	if err != nil {
+		status.Error = err.Error()
+		if conf.Dsn != "" {
+			status.Error = strings.Replace(status.Error, conf.Dsn, "[dsn]", -1)
+		}
+		return status
+	}
+	status.OK = true
+	return status
+}
@@ -0,0 +1,140 @@
+package restapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+)
+
+// JSONSchema is the subset of JSON Schema used to validate request bodies of
+// mutation docs: type, required, properties, additionalProperties, items,
+// enum, minimum, maximum, minLength, maxLength and pattern.
+type JSONSchema struct {
+	Type                 string                 `yaml:"type,omitempty"`
+	Required             []string               `yaml:"required,omitempty"`
+	Properties           map[string]*JSONSchema `yaml:"properties,omitempty"`
+	AdditionalProperties *bool                  `yaml:"additionalProperties,omitempty"`
+	Items                *JSONSchema            `yaml:"items,omitempty"`
+	Enum                 []interface{}          `yaml:"enum,omitempty"`
+	Minimum              *float64               `yaml:"minimum,omitempty"`
+	Maximum              *float64               `yaml:"maximum,omitempty"`
+	MinLength            *int                   `yaml:"minLength,omitempty"`
+	MaxLength            *int                   `yaml:"maxLength,omitempty"`
+	Pattern              string                 `yaml:"pattern,omitempty"`
+}
+
+// Validate checks v, decoded by encoding/json with UseNumber, against the
+// schema
+func (s *JSONSchema) Validate(v interface{}) error {
+	return s.validate(v, "body")
+}
+
+func (s *JSONSchema) validate(v interface{}, path string) error {
+	if s == nil {
+		return nil
+	}
+
+	if s.Type != "" && !jsonTypeMatches(s.Type, v) {
+		return fmt.Errorf("%s must be of type %s", path, s.Type)
+	}
+
+	if len(s.Enum) > 0 && !jsonEnumContains(s.Enum, v) {
+		return fmt.Errorf("%s must be one of %v", path, s.Enum)
+	}
+
+	switch val := v.(type) {
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := val[name]; !ok {
+				return fmt.Errorf("%s.%s is required", path, name)
+			}
+		}
+
+		names := make([]string, 0, len(val))
+		for name := range val {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			prop, ok := s.Properties[name]
+			if !ok {
+				if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+					return fmt.Errorf("%s.%s is not allowed", path, name)
+				}
+				continue
+			}
+			if err := prop.validate(val[name], path+"."+name); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for i, item := range val {
+			if err := s.Items.validate(item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	case string:
+		length := len([]rune(val))
+		if s.MinLength != nil && length < *s.MinLength {
+			return fmt.Errorf("%s must be at least %d characters", path, *s.MinLength)
+		}
+		if s.MaxLength != nil && length > *s.MaxLength {
+			return fmt.Errorf("%s must be at most %d characters", path, *s.MaxLength)
+		}
+		if s.Pattern != "" {
+			re, err := regexp.Compile(s.Pattern)
+			if err != nil {
+				return fmt.Errorf("%s has an invalid pattern in schema", path)
+			}
+			if !re.MatchString(val) {
+				return fmt.Errorf("%s does not match %s", path, s.Pattern)
+			}
+		}
+	case json.Number:
+		f, err := val.Float64()
+		if err != nil {
+			return fmt.Errorf("%s is not a valid number", path)
+		}
+		if s.Minimum != nil && f < *s.Minimum {
+			return fmt.Errorf("%s must be >= %v", path, *s.Minimum)
+		}
+		if s.Maximum != nil && f > *s.Maximum {
+			return fmt.Errorf("%s must be <= %v", path, *s.Maximum)
+		}
+	}
+
+	return nil
+}
+
+func jsonTypeMatches(t string, v interface{}) bool {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		return t == "object"
+	case []interface{}:
+		return t == "array"
+	case string:
+		return t == "string"
+	case bool:
+		return t == "boolean"
+	case nil:
+		return t == "null"
+	case json.Number:
+		if t == "number" {
+			return true
+		}
+		_, err := val.Int64()
+		return t == "integer" && err == nil
+	}
+	return false
+}
+
+func jsonEnumContains(enum []interface{}, v interface{}) bool {
+	for _, e := range enum {
+		if fmt.Sprint(e) == fmt.Sprint(v) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,220 @@
+package restapi
+
+import (
+	"container/list"
+	"expvar"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// the least recently seen buckets are dropped once this many clients are
+// tracked
+const maxTrackedClients = 10000
+
+// LimitSpec overrides the deployment limits for one doc
+type LimitSpec struct {
+	// Concurrency is the max number of queries of the doc running at once
+	Concurrency int `yaml:"concurrency,omitempty"`
+}
+
+// rateLimiter is a token bucket per client. Buckets refill at rate tokens a
+// second up to burst.
+type rateLimiter struct {
+	mu      sync.Mutex
+	rate    float64
+	burst   float64
+	buckets map[string]*list.Element
+	// recent orders the buckets from the most recently seen
+	recent *list.List
+}
+
+type bucket struct {
+	client string
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	if burst < 1 {
+		burst = int(math.Max(1, math.Ceil(rate)))
+	}
+	return &rateLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: map[string]*list.Element{},
+		recent:  list.New(),
+	}
+}
+
+// allow takes a token of the client, or returns how long to wait for one
+func (l *rateLimiter) allow(client string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e, ok := l.buckets[client]
+	if ok {
+		l.recent.MoveToFront(e)
+	} else {
+		for len(l.buckets) >= maxTrackedClients {
+			oldest := l.recent.Back()
+			l.recent.Remove(oldest)
+			delete(l.buckets, oldest.Value.(*bucket).client)
+		}
+		e = l.recent.PushFront(&bucket{client: client, tokens: l.burst, last: now})
+		l.buckets[client] = e
+	}
+	b := e.Value.(*bucket)
+
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+}
+
+func (l *rateLimiter) clients() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.buckets)
+}
+
+// concurrencyLimiter counts the queries in flight per key
+type concurrencyLimiter struct {
+	mu       sync.Mutex
+	inFlight map[string]int
+}
+
+func newConcurrencyLimiter() *concurrencyLimiter {
+	return &concurrencyLimiter{inFlight: map[string]int{}}
+}
+
+// acquire a slot of key, max <= 0 means unlimited
+func (l *concurrencyLimiter) acquire(key string, max int) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if max > 0 && l.inFlight[key] >= max {
+		return false
+	}
+	l.inFlight[key]++
+	return true
+}
+
+func (l *concurrencyLimiter) release(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.inFlight[key] <= 1 {
+		delete(l.inFlight, key)
+		return
+	}
+	l.inFlight[key]--
+}
+
+func (l *concurrencyLimiter) snapshot() map[string]int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	inFlight := make(map[string]int, len(l.inFlight))
+	for k, v := range l.inFlight {
+		inFlight[k] = v
+	}
+	return inFlight
+}
+
+// limits holds the limiter state of a Service
+type limits struct {
+	rate  *rateLimiter
+	docs  *concurrencyLimiter
+	dbs   *concurrencyLimiter
+	stats *expvar.Map
+}
+
+func newLimits(conf Config) *limits {
+	l := &limits{
+		docs:  newConcurrencyLimiter(),
+		dbs:   newConcurrencyLimiter(),
+		stats: new(expvar.Map).Init(),
+	}
+	if conf.Rate > 0 {
+		l.rate = newRateLimiter(conf.Rate, conf.Burst)
+	}
+
+	l.stats.Set("doc_in_flight", expvar.Func(func() interface{} { return l.docs.snapshot() }))
+	l.stats.Set("db_in_flight", expvar.Func(func() interface{} { return l.dbs.snapshot() }))
+	l.stats.Set("rate_clients", expvar.Func(func() interface{} {
+		if l.rate == nil {
+			return 0
+		}
+		return l.rate.clients()
+	}))
+	return l
+}
+
+// Metrics exposes the limiter state, to be published with expvar
+func (s *Service) Metrics() expvar.Var {
+	return s.limits.stats
+}
+
+// RateLimit is a middleware rejecting clients that exceed the configured
+// rate. Clients are told apart by the verified caller subject, or else the
+// client ip; request headers are not trusted to name the client.
+func (s *Service) RateLimit(c *gin.Context) {
+	if s.limits.rate == nil {
+		c.Next()
+		return
+	}
+
+	client := "ip:" + c.ClientIP()
+	if caller := callerOf(c); !caller.Anonymous() {
+		client = "sub:" + caller.Subject
+	}
+
+	if ok, wait := s.limits.rate.allow(client, time.Now()); !ok {
+		s.limits.stats.Add("rate_limited", 1)
+		tooManyRequests(c, wait, 42901, "rate limit exceeded")
+		return
+	}
+	c.Next()
+}
+
+// acquireQuery takes a concurrency slot of the doc and of its database. The
+// returned func releases both.
+func (s *Service) acquireQuery(c *gin.Context, path string, spec *DocSpec, dbName string) (func(), bool) {
+	max := s.conf.DocConcurrency
+	if spec.Composition.Limits != nil && spec.Composition.Limits.Concurrency > 0 {
+		max = spec.Composition.Limits.Concurrency
+	}
+
+	if !s.limits.docs.acquire(path, max) {
+		s.limits.stats.Add("doc_rejected", 1)
+		tooManyRequests(c, time.Second, 42902, fmt.Sprintf("too many concurrent queries on %s", path))
+		return nil, false
+	}
+	if !s.limits.dbs.acquire(dbName, s.conf.DbConcurrency) {
+		s.limits.docs.release(path)
+		s.limits.stats.Add("db_rejected", 1)
+		tooManyRequests(c, time.Second, 42903, fmt.Sprintf("too many concurrent queries on database %s", dbName))
+		return nil, false
+	}
+
+	return func() {
+		s.limits.dbs.release(dbName)
+		s.limits.docs.release(path)
+	}, true
+}
+
+func tooManyRequests(c *gin.Context, wait time.Duration, code int, message string) {
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	c.AbortWithStatusJSON(http.StatusTooManyRequests, Error{
+		Code:    code,
+		Message: message,
+	})
+}
@@ -0,0 +1,116 @@
+package restapi
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterBucket(t *testing.T) {
+	l := newRateLimiter(2, 3)
+	now := time.Unix(1600000000, 0)
+
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.allow("a", now); !ok {
+			t.Fatalf("request %d of the burst was limited", i+1)
+		}
+	}
+	ok, wait := l.allow("a", now)
+	if ok {
+		t.Fatalf("a request over the burst was allowed")
+	}
+	if wait != 500*time.Millisecond {
+		t.Errorf("wait is %s, want 500ms", wait)
+	}
+
+	if ok, _ := l.allow("b", now); !ok {
+		t.Errorf("another client was limited")
+	}
+
+	if ok, _ := l.allow("a", now.Add(500*time.Millisecond)); !ok {
+		t.Errorf("a refilled token was not given")
+	}
+	if ok, _ := l.allow("a", now.Add(500*time.Millisecond)); ok {
+		t.Errorf("a token was given twice")
+	}
+
+	// refills stop at the burst
+	later := now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.allow("a", later); !ok {
+			t.Fatalf("request %d after a pause was limited", i+1)
+		}
+	}
+	if ok, _ := l.allow("a", later); ok {
+		t.Errorf("the bucket filled over its burst")
+	}
+}
+
+func TestRateLimiterDefaultBurst(t *testing.T) {
+	l := newRateLimiter(0.5, 0)
+	now := time.Unix(1600000000, 0)
+
+	if ok, _ := l.allow("a", now); !ok {
+		t.Fatalf("the first request was limited")
+	}
+	ok, wait := l.allow("a", now)
+	if ok || wait != 2*time.Second {
+		t.Errorf("second request allowed %v, wait %s, want a 2s wait", ok, wait)
+	}
+}
+
+func TestRateLimiterEvictsLeastRecentlySeen(t *testing.T) {
+	l := newRateLimiter(1, 1)
+	now := time.Unix(1600000000, 0)
+
+	for i := 0; i < maxTrackedClients; i++ {
+		l.allow(fmt.Sprintf("c%d", i), now)
+	}
+	// c0 is seen again and its bucket stays empty
+	if ok, _ := l.allow("c0", now); ok {
+		t.Fatalf("an empty bucket gave a token")
+	}
+
+	l.allow("new", now)
+	if n := l.clients(); n != maxTrackedClients {
+		t.Errorf("%d clients are tracked, want %d", n, maxTrackedClients)
+	}
+	if _, ok := l.buckets["c1"]; ok {
+		t.Errorf("the least recently seen client was kept")
+	}
+	if ok, _ := l.allow("c0", now); ok {
+		t.Errorf("a recently seen client was evicted and got a full bucket")
+	}
+}
+
+func TestConcurrencyLimiter(t *testing.T) {
+	l := newConcurrencyLimiter()
+
+	if !l.acquire("doc", 2) || !l.acquire("doc", 2) {
+		t.Fatalf("a slot under the max was refused")
+	}
+	if l.acquire("doc", 2) {
+		t.Errorf("a slot over the max was given")
+	}
+	if !l.acquire("other", 2) {
+		t.Errorf("another key was refused")
+	}
+
+	l.release("doc")
+	if !l.acquire("doc", 2) {
+		t.Errorf("a released slot was not given again")
+	}
+
+	l.release("doc")
+	l.release("doc")
+	l.release("other")
+	if n := len(l.snapshot()); n != 0 {
+		t.Errorf("%d keys are left after releasing every slot", n)
+	}
+
+	for i := 0; i < 100; i++ {
+		if !l.acquire("unlimited", 0) {
+			t.Fatalf("an unlimited key was refused")
+		}
+	}
+}
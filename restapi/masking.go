@@ -0,0 +1,243 @@
+package restapi
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/wangxb07/sqlcomposer"
+	"gitlab.com/beehplus/sql-compose/sqlident"
+	"regexp"
+	"strings"
+)
+
+const (
+	maskRedact  = "redact"
+	maskPartial = "partial"
+	maskHash    = "hash"
+	maskNull    = "null"
+
+	redactedValue = "***"
+)
+
+// MaskSpec is the masking policy of one result field. Callers whose role is
+// listed in Roles get the raw value, everyone else gets it masked by Rule:
+// redact, partial, hash (keyed by Config.MaskKey) or null.
+type MaskSpec struct {
+	Rule  string   `yaml:"rule"`
+	Roles []string `yaml:"roles,omitempty"`
+}
+
+func (m *MaskSpec) allows(role string) bool {
+	if role == "" {
+		return false
+	}
+	for _, r := range m.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// checkDocMasking validates the masking policies of a doc before it is saved
+func checkDocMasking(spec *DocSpec) error {
+	for field, m := range spec.Composition.Masking {
+		if m == nil {
+			return fmt.Errorf("masking of %s requires a rule", field)
+		}
+		switch m.Rule {
+		case maskRedact, maskPartial, maskHash, maskNull:
+		default:
+			return fmt.Errorf("masking rule %q of %s is invalid, use redact, partial, hash or null", m.Rule, field)
+		}
+		// the cursor would hand out the raw value of the field
+		if spec.Composition.Cursor != nil && spec.Composition.Cursor.Attr == field {
+			return fmt.Errorf("cursor attr %s can not be masked", field)
+		}
+	}
+	return nil
+}
+
+// maskedAttrs are the attrs the role may only see masked: the masked result
+// fields, and the columns they are selected from
+type maskedAttrs map[string]bool
+
+func maskedAttrsOf(doc *sqlcomposer.SqlApiDoc, masking map[string]*MaskSpec, role string) maskedAttrs {
+	masked := maskedAttrs{}
+	for field, m := range masking {
+		if !m.allows(role) {
+			masked[field] = true
+		}
+	}
+	if len(masked) == 0 {
+		return masked
+	}
+
+	for _, group := range doc.Composition.Fields {
+		for _, f := range group {
+			if masked[f.Name] && sqlident.ValidQualified(f.Expr) {
+				masked[f.Expr] = true
+			}
+		}
+	}
+	return masked
+}
+
+// has reports whether attr, qualified or not, is masked
+func (masked maskedAttrs) has(attr string) bool {
+	return masked[attr] || masked[attr[strings.LastIndex(attr, ".")+1:]]
+}
+
+var exprIdentPattern = regexp.MustCompile(`[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)*`)
+
+// referredBy reports whether the sql expression expr refers to a masked
+// attr, plain or quoted. An expr that can not be read refers to all.
+func (masked maskedAttrs) referredBy(expr string) bool {
+	segments, err := sqlSegments(expr)
+	if err != nil {
+		return true
+	}
+
+	for _, seg := range segments {
+		switch {
+		case seg.code:
+			for _, ident := range exprIdentPattern.FindAllString(seg.text, -1) {
+				if masked.has(ident) {
+					return true
+				}
+			}
+		case strings.HasPrefix(seg.text, "`"):
+			if masked.has(strings.Trim(seg.text, "`")) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkMaskedAccess rejects filters and sorts on masked attrs: matching or
+// ordering by a value, e.g. with starts_with, discloses it a bit at a time.
+// Aggregate dimensions and measures over masked attrs are rejected too, a
+// group key or the min and max of a column are raw values.
+func checkMaskedAccess(masked maskedAttrs, sorts SortSpec, aggregates *AggregateSpec, req *GetResultRequest) error {
+	if len(masked) == 0 {
+		return nil
+	}
+
+	if req.Aggregate != nil && aggregates != nil {
+		for _, name := range req.Aggregate.Dimensions {
+			if d, ok := aggregates.dimension(name); ok && masked.referredBy(d.Expr) {
+				return fmt.Errorf("%s is made of masked fields and can not be grouped by", name)
+			}
+		}
+		for _, name := range req.Aggregate.Measures {
+			if m, ok := aggregates.measure(name); ok && masked.referredBy(m.Expr) {
+				return fmt.Errorf("%s is made of masked fields and can not be measured", name)
+			}
+		}
+	}
+
+	for _, f := range req.Filters {
+		if masked.has(f.Attr) {
+			return fmt.Errorf("%s is masked and can not be filtered on", f.Attr)
+		}
+	}
+
+	var walk func(n *FilterNode) error
+	walk = func(n *FilterNode) error {
+		if n == nil {
+			return nil
+		}
+		if n.isLeaf() {
+			if masked.has(n.Attr) {
+				return fmt.Errorf("%s is masked and can not be filtered on", n.Attr)
+			}
+			return nil
+		}
+		for _, child := range append(append([]*FilterNode{n.Not}, n.And...), n.Or...) {
+			if err := walk(child); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := walk(req.Where); err != nil {
+		return err
+	}
+
+	for _, item := range req.OrderBy {
+		if item == nil {
+			continue
+		}
+		expr, _ := sorts.expr(item.Attr)
+		if masked.has(item.Attr) || masked.has(expr) {
+			return fmt.Errorf("%s is masked and can not be sorted on", item.Attr)
+		}
+	}
+	return nil
+}
+
+// maskRows masks the fields of result rows the role may not see, in place
+func maskRows(rows []interface{}, masking map[string]*MaskSpec, role string, key []byte) {
+	if len(masking) == 0 {
+		return
+	}
+
+	var masked []string
+	for field, m := range masking {
+		if !m.allows(role) {
+			masked = append(masked, field)
+		}
+	}
+	if len(masked) == 0 {
+		return
+	}
+
+	for _, row := range rows {
+		item, ok := row.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for _, field := range masked {
+			if v, ok := item[field]; ok {
+				item[field] = maskValue(masking[field].Rule, v, key)
+			}
+		}
+	}
+}
+
+func maskValue(rule string, v interface{}, key []byte) interface{} {
+	if v == nil {
+		return nil
+	}
+
+	s := fmt.Sprint(v)
+	switch rule {
+	case maskPartial:
+		return maskPartially(s)
+	case maskHash:
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(s))
+		return hex.EncodeToString(mac.Sum(nil))
+	case maskNull:
+		return nil
+	}
+	return redactedValue
+}
+
+// maskPartially keeps the first character of the local part of an email,
+// and about a quarter of the characters at both ends of other values
+func maskPartially(s string) string {
+	if at := strings.LastIndex(s, "@"); at > 0 {
+		local := []rune(s[:at])
+		return string(local[0]) + redactedValue + s[at:]
+	}
+
+	r := []rune(s)
+	if len(r) <= 4 {
+		return strings.Repeat("*", len(r))
+	}
+	keep := len(r) / 4
+	return string(r[:keep]) + strings.Repeat("*", len(r)-2*keep) + string(r[len(r)-keep:])
+}
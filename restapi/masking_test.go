@@ -0,0 +1,112 @@
+package restapi
+
+import (
+	"github.com/wangxb07/sqlcomposer"
+	"testing"
+)
+
+func maskingTestDoc() (*sqlcomposer.SqlApiDoc, map[string]*MaskSpec) {
+	doc := &sqlcomposer.SqlApiDoc{}
+	doc.Composition.Fields = sqlcomposer.SqlCompositionFields{
+		"base": {{Name: "ip", Expr: "ip_address"}, {Name: "email", Expr: "o.email"}, {Name: "state", Expr: "state"}},
+	}
+	masking := map[string]*MaskSpec{
+		"ip":    {Rule: maskPartial, Roles: []string{"admin"}},
+		"email": {Rule: maskHash, Roles: []string{"admin"}},
+	}
+	return doc, masking
+}
+
+var maskingTestAggregates = &AggregateSpec{
+	From: "commerce_order o",
+	Dimensions: []AggregateDimension{
+		{Name: "state", Expr: "state"},
+		{Name: "ip", Expr: "ip_address"},
+		{Name: "subnet", Expr: "SUBSTRING_INDEX(o.ip_address, '.', 3)"},
+		{Name: "domain", Expr: "SUBSTRING_INDEX(`o`.`email`, '@', -1)"},
+		{Name: "day", Expr: "DATE(placed)"},
+	},
+	Measures: []AggregateMeasure{
+		{Name: "orders", Func: "count", Expr: "order_id"},
+		{Name: "last_ip", Func: "max", Expr: "ip_address"},
+		{Name: "first_email", Func: "min", Expr: "email"},
+		{Name: "emails", Func: "count_distinct", Expr: "LOWER(o.email)"},
+		{Name: "literal", Func: "count", Expr: "'ip_address'"},
+	},
+}
+
+func TestCheckMaskedAccessRejectsMaskedDimensions(t *testing.T) {
+	doc, masking := maskingTestDoc()
+
+	for _, dim := range []string{"ip", "subnet", "domain"} {
+		req := &GetResultRequest{Aggregate: &GetResultAggregate{Dimensions: []string{dim}, Measures: []string{"orders"}}}
+		if err := checkMaskedAccess(maskedAttrsOf(doc, masking, "viewer"), SortSpec{}, maskingTestAggregates, req); err == nil {
+			t.Errorf("viewer was allowed to group by %s", dim)
+		}
+		if err := checkMaskedAccess(maskedAttrsOf(doc, masking, "admin"), SortSpec{}, maskingTestAggregates, req); err != nil {
+			t.Errorf("admin was refused to group by %s: %v", dim, err)
+		}
+	}
+}
+
+func TestCheckMaskedAccessRejectsMaskedMeasures(t *testing.T) {
+	doc, masking := maskingTestDoc()
+
+	for _, measure := range []string{"last_ip", "first_email", "emails"} {
+		req := &GetResultRequest{Aggregate: &GetResultAggregate{Dimensions: []string{"state"}, Measures: []string{"orders", measure}}}
+		if err := checkMaskedAccess(maskedAttrsOf(doc, masking, ""), SortSpec{}, maskingTestAggregates, req); err == nil {
+			t.Errorf("an anonymous caller was allowed to measure %s", measure)
+		}
+		if err := checkMaskedAccess(maskedAttrsOf(doc, masking, "admin"), SortSpec{}, maskingTestAggregates, req); err != nil {
+			t.Errorf("admin was refused to measure %s: %v", measure, err)
+		}
+	}
+
+	req := &GetResultRequest{Aggregate: &GetResultAggregate{Dimensions: []string{"state", "day"}, Measures: []string{"orders", "literal"}}}
+	if err := checkMaskedAccess(maskedAttrsOf(doc, masking, "viewer"), SortSpec{}, maskingTestAggregates, req); err != nil {
+		t.Errorf("an aggregate of unmasked columns was refused: %v", err)
+	}
+}
+
+func TestMaskRows(t *testing.T) {
+	_, masking := maskingTestDoc()
+	masking["state"] = &MaskSpec{Rule: maskNull}
+	key := []byte("k")
+
+	row := func() map[string]interface{} {
+		return map[string]interface{}{"ip": "192.168.10.1", "email": "jane@example.com", "state": "paid", "id": 1}
+	}
+
+	rows := []interface{}{row()}
+	maskRows(rows, masking, "viewer", key)
+	got := rows[0].(map[string]interface{})
+	if got["ip"] != "192******0.1" {
+		t.Errorf("ip is %v", got["ip"])
+	}
+	if got["email"] == "jane@example.com" || got["email"] != maskValue(maskHash, "jane@example.com", key) || len(got["email"].(string)) != 64 {
+		t.Errorf("email is %v", got["email"])
+	}
+	if got["state"] != nil || got["id"] != 1 {
+		t.Errorf("row is %v", got)
+	}
+
+	rows = []interface{}{row()}
+	maskRows(rows, masking, "admin", key)
+	got = rows[0].(map[string]interface{})
+	if got["ip"] != "192.168.10.1" || got["email"] != "jane@example.com" || got["state"] != nil {
+		t.Errorf("admin row is %v", got)
+	}
+}
+
+func TestMaskPartially(t *testing.T) {
+	for in, want := range map[string]string{
+		"jane@example.com": "j***@example.com",
+		"192.168.10.1":     "192******0.1",
+		"abcd":             "****",
+		"":                 "",
+	} {
+		if got := maskPartially(in); got != want {
+			t.Errorf("maskPartially(%q) is %q, want %q", in, got, want)
+		}
+	}
+}
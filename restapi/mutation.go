@@ -0,0 +1,397 @@
+package restapi
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+	"gitlab.com/beehplus/sql-compose/entity"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const docKindMutation = "mutation"
+
+const defaultMutationTimeout = 5 * time.Minute
+
+const (
+	mutationPending   = "pending"
+	mutationSucceeded = "succeeded"
+	mutationFailed    = "failed"
+)
+
+var writeStatementPattern = regexp.MustCompile(`^(?i)(INSERT|UPDATE|DELETE|REPLACE)\b`)
+
+// MutationSpec is the composition of a mutation doc. Statements are run in
+// order with the named parameters (:name) bound from the request body, which
+// is validated against Schema first.
+type MutationSpec struct {
+	Statements  []string    `yaml:"statements"`
+	Transaction bool        `yaml:"transaction,omitempty"`
+	Schema      *JSONSchema `yaml:"schema,omitempty"`
+}
+
+type MutationResult struct {
+	RowsAffected int64 `json:"rows_affected"`
+	LastInsertID int64 `json:"last_insert_id,omitempty"`
+}
+
+// checkMutation validates a mutation doc before it is saved
+func checkMutation(spec *MutationSpec) error {
+	if spec == nil || len(spec.Statements) == 0 {
+		return fmt.Errorf("mutation doc requires at least one statement")
+	}
+
+	for i, stmt := range spec.Statements {
+		code, err := stripLiterals(stmt)
+		if err != nil {
+			return fmt.Errorf("statement %d: %v", i+1, err)
+		}
+		code = strings.TrimSpace(code)
+
+		if j := strings.Index(code, ";"); j >= 0 && strings.TrimSpace(code[j+1:]) != "" {
+			return fmt.Errorf("statement %d: multiple statements are not allowed", i+1)
+		}
+		if !writeStatementPattern.MatchString(code) {
+			return fmt.Errorf("statement %d: only INSERT, UPDATE and DELETE statements are allowed", i+1)
+		}
+	}
+	return nil
+}
+
+func (s *Service) Mutate(c *gin.Context) {
+	path := c.Param("path")
+
+	var docEntity entity.Doc
+	if err := s.Db.Get(&docEntity, "select * from doc WHERE path=?", path); err != nil {
+		log.Error(err)
+		c.JSON(http.StatusNotFound, Error{
+			Code:    40005,
+			Message: "this path does not exist",
+		})
+		return
+	}
+
+	spec, err := parseDocSpec([]byte(*docEntity.Content))
+	if err != nil {
+		log.Warn(err)
+		c.JSON(http.StatusBadRequest, Error{
+			Code:    40007,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	if !spec.isMutation() {
+		c.JSON(http.StatusBadRequest, Error{
+			Code:    40019,
+			Message: "this doc is not a mutation",
+		})
+		return
+	}
+	if !s.conf.writable(path) {
+		c.JSON(http.StatusForbidden, Error{
+			Code:    40019,
+			Message: "this mutation is not enabled",
+		})
+		return
+	}
+
+	release, ok := s.acquireQuery(c, path, spec, docEntity.DB)
+	if !ok {
+		return
+	}
+	defer release()
+
+	raw, err := c.GetRawData()
+	if err != nil {
+		log.Error(err)
+		c.JSON(http.StatusBadRequest, Error{
+			Code:    40006,
+			Message: "request body error",
+		})
+		return
+	}
+
+	var body interface{}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	if err := dec.Decode(&body); err != nil {
+		c.JSON(http.StatusBadRequest, Error{
+			Code:    40006,
+			Message: "request body must be json",
+		})
+		return
+	}
+
+	if err := spec.Composition.Mutation.Schema.Validate(body); err != nil {
+		c.JSON(http.StatusBadRequest, Error{
+			Code:    40018,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	args, ok := body.(map[string]interface{})
+	if !ok {
+		c.JSON(http.StatusBadRequest, Error{
+			Code:    40018,
+			Message: "request body must be a json object",
+		})
+		return
+	}
+
+	hash, err := requestHash(body)
+	if err != nil {
+		log.Error(err)
+		c.JSON(http.StatusBadRequest, Error{
+			Code:    40006,
+			Message: "request body error",
+		})
+		return
+	}
+
+	logID, replay, err := s.startMutationLog(c, &docEntity, path, string(raw), hash)
+	if err != nil {
+		log.Error(err)
+		c.JSON(http.StatusInternalServerError, Error{
+			Code:    50001,
+			Message: "can not record the mutation",
+		})
+		return
+	}
+	if replay != nil {
+		replayMutation(c, replay, hash)
+		return
+	}
+
+	status, response := http.StatusOK, interface{}(nil)
+	ctx, cancel := s.queryContext(c.Request.Context())
+	defer cancel()
+	// a pending log is only taken over once the execution can not be running
+	ctx, cancelTimeout := context.WithTimeout(ctx, s.mutationTimeout())
+	defer cancelTimeout()
+
+	result, err := s.execMutation(ctx, &docEntity, spec.Composition.Mutation, args)
+	if err != nil {
+		log.Error(err)
+		status, response = http.StatusBadRequest, Error{
+			Code:    40020,
+			Message: err.Error(),
+		}
+	} else {
+		response = result
+	}
+
+	s.finishMutationLog(logID, status, response)
+	c.JSON(status, response)
+}
+
+// replayMutation answers a request whose idempotency key was used before
+// with the response of the earlier request, once it is finished and when the
+// bodies are the same.
+func replayMutation(c *gin.Context, prev *entity.MutationLog, hash string) {
+	if prev.RequestHash != hash {
+		c.JSON(http.StatusUnprocessableEntity, Error{
+			Code:    42201,
+			Message: "this idempotency key was used with a different request body",
+		})
+		return
+	}
+	if prev.Status == mutationPending || prev.Response == nil {
+		c.JSON(http.StatusConflict, Error{
+			Code:    40901,
+			Message: "a request with this idempotency key is in progress",
+		})
+		return
+	}
+	c.Header("Idempotent-Replayed", "true")
+	c.Data(prev.StatusCode, "application/json; charset=utf-8", []byte(*prev.Response))
+}
+
+func (s *Service) execMutation(ctx context.Context, docEntity *entity.Doc, spec *MutationSpec, args map[string]interface{}) (*MutationResult, error) {
+	var dbConfig entity.DataBaseConfig
+	if err := s.Db.Get(&dbConfig, "SELECT * FROM database_config WHERE name=?", docEntity.DB); err != nil {
+		return nil, fmt.Errorf("please check dbname")
+	}
+
+	db, releaseDb, err := s.pool.GetContext(ctx, dbConfig.Name, dbConfig.Dsn)
+	if err != nil {
+		return nil, fmt.Errorf("database connection error")
+	}
+	defer releaseDb()
+
+	var execer sqlx.ExecerContext = db
+	var tx *sqlx.Tx
+	if spec.Transaction {
+		tx, err = db.BeginTxx(ctx, nil)
+		if err != nil {
+			return nil, err
+		}
+		defer tx.Rollback()
+		execer = tx
+	}
+
+	bound := bindableArgs(args)
+	result := &MutationResult{}
+	for _, stmt := range spec.Statements {
+		q, a, err := sqlx.Named(stmt, bound)
+		if err != nil {
+			return nil, err
+		}
+		q, a, err = sqlx.In(q, a...)
+		if err != nil {
+			return nil, err
+		}
+
+		res, err := execer.ExecContext(ctx, db.Rebind(q), a...)
+		if err != nil {
+			return nil, err
+		}
+
+		if n, err := res.RowsAffected(); err == nil {
+			result.RowsAffected += n
+		}
+		if id, err := res.LastInsertId(); err == nil && id > 0 {
+			result.LastInsertID = id
+		}
+	}
+
+	if tx != nil {
+		if err := tx.Commit(); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// bindableArgs converts json numbers of the body to values the driver binds
+func bindableArgs(args map[string]interface{}) map[string]interface{} {
+	bound := make(map[string]interface{}, len(args))
+	for k, v := range args {
+		bound[k] = bindableValue(v)
+	}
+	return bound
+}
+
+func bindableValue(v interface{}) interface{} {
+	switch val := v.(type) {
+	case json.Number:
+		if i, err := val.Int64(); err == nil {
+			return i
+		}
+		f, _ := val.Float64()
+		return f
+	case []interface{}:
+		items := make([]interface{}, len(val))
+		for i, item := range val {
+			items[i] = bindableValue(item)
+		}
+		return items
+	}
+	return v
+}
+
+func (s *Service) mutationTimeout() time.Duration {
+	if s.conf.MutationTimeout > 0 {
+		return s.conf.MutationTimeout
+	}
+	return defaultMutationTimeout
+}
+
+// requestHash identifies a request body regardless of its formatting and key
+// order
+func requestHash(body interface{}) (string, error) {
+	canonical, err := json.Marshal(body)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(canonical)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// startMutationLog records a pending execution. When the idempotency key was
+// used before on this path the earlier record is returned instead, unless it
+// is pending for longer than the mutation timeout with the same body, then
+// the execution takes it over.
+func (s *Service) startMutationLog(c *gin.Context, docEntity *entity.Doc, path string, body string, hash string) (int64, *entity.MutationLog, error) {
+	var key *string
+	if k := c.GetHeader("Idempotency-Key"); k != "" {
+		key = &k
+	}
+
+	res, err := s.Db.NamedExec(`INSERT INTO mutation_log (doc_uuid,path,idempotency_key,request_body,request_hash,status,client_ip,created_at,updated_at) VALUES (:doc_uuid,:path,:idempotency_key,:request_body,:request_hash,:status,:client_ip,:created_at,:updated_at)`,
+		map[string]interface{}{
+			"doc_uuid":        *docEntity.UUID,
+			"path":            path,
+			"idempotency_key": key,
+			"request_body":    body,
+			"request_hash":    hash,
+			"status":          mutationPending,
+			"client_ip":       c.ClientIP(),
+			"created_at":      time.Now().Unix(),
+			"updated_at":      time.Now().Unix(),
+		})
+	if err != nil {
+		if me, ok := err.(*mysql.MySQLError); ok && me.Number == 1062 && key != nil {
+			var prev entity.MutationLog
+			if err := s.Db.Get(&prev, "SELECT * FROM mutation_log WHERE path=? AND idempotency_key=?", path, *key); err != nil {
+				return 0, nil, err
+			}
+			if prev.Status == mutationPending && prev.RequestHash == hash {
+				if reclaimed, err := s.reclaimMutationLog(c, &prev); err != nil || reclaimed {
+					return prev.ID, nil, err
+				}
+			}
+			return 0, &prev, nil
+		}
+		return 0, nil, err
+	}
+
+	id, err := res.LastInsertId()
+	return id, nil, err
+}
+
+// reclaimMutationLog takes over a pending log whose execution outlived the
+// mutation timeout, e.g. because the process died. Only one retry wins.
+func (s *Service) reclaimMutationLog(c *gin.Context, prev *entity.MutationLog) (bool, error) {
+	if prev.UpdatedAt == nil || time.Since(time.Unix(int64(*prev.UpdatedAt), 0)) < s.mutationTimeout() {
+		return false, nil
+	}
+
+	res, err := s.Db.Exec("UPDATE mutation_log SET client_ip=?,updated_at=? WHERE id=? AND status=? AND updated_at=?",
+		c.ClientIP(), time.Now().Unix(), prev.ID, mutationPending, *prev.UpdatedAt)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if n == 1 {
+		log.Warnf("mutation log %d was pending for longer than %s, it is taken over", prev.ID, s.mutationTimeout())
+	}
+	return n == 1, nil
+}
+
+func (s *Service) finishMutationLog(id int64, status int, response interface{}) {
+	state := mutationSucceeded
+	if status != http.StatusOK {
+		state = mutationFailed
+	}
+
+	encoded, _ := json.Marshal(response)
+	_, err := s.Db.Exec("UPDATE mutation_log SET status=?,status_code=?,response=?,updated_at=? WHERE id=?",
+		state, status, string(encoded), time.Now().Unix(), id)
+	if err != nil {
+		log.Error(err)
+	}
+}
@@ -0,0 +1,126 @@
+package restapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"gitlab.com/beehplus/sql-compose/entity"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func decodeBody(t *testing.T, raw string) interface{} {
+	t.Helper()
+
+	var body interface{}
+	dec := json.NewDecoder(bytes.NewReader([]byte(raw)))
+	dec.UseNumber()
+	if err := dec.Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+func TestRequestHash(t *testing.T) {
+	hash := func(raw string) string {
+		h, err := requestHash(decodeBody(t, raw))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+
+	same := hash(`{"id": 1, "tags": ["a", "b"], "note": {"x": 1, "y": 2}}`)
+	if got := hash(`{"note":{"y":2,"x":1},"tags":["a","b"],"id":1}`); got != same {
+		t.Errorf("reordered and reformatted body hashes to %s, want %s", got, same)
+	}
+
+	for _, raw := range []string{
+		`{"id": 2, "tags": ["a", "b"], "note": {"x": 1, "y": 2}}`,
+		`{"id": 1, "tags": ["b", "a"], "note": {"x": 1, "y": 2}}`,
+		`{"id": "1", "tags": ["a", "b"], "note": {"x": 1, "y": 2}}`,
+		`{"id": 1, "tags": ["a", "b"]}`,
+	} {
+		if hash(raw) == same {
+			t.Errorf("%s hashes like a different body", raw)
+		}
+	}
+}
+
+func replay(prev *entity.MutationLog, hash string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	replayMutation(c, prev, hash)
+	return w
+}
+
+func TestReplayMutation(t *testing.T) {
+	response := `{"rows_affected":1,"last_insert_id":7}`
+	done := &entity.MutationLog{
+		RequestHash: "h1",
+		Status:      mutationSucceeded,
+		StatusCode:  http.StatusOK,
+		Response:    &response,
+	}
+
+	w := replay(done, "h1")
+	if w.Code != http.StatusOK || w.Body.String() != response {
+		t.Errorf("replay answered %d %s, want 200 %s", w.Code, w.Body, response)
+	}
+	if w.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("a replay is not marked")
+	}
+
+	failedResponse := `{"code":40020,"message":"duplicate entry"}`
+	failed := &entity.MutationLog{
+		RequestHash: "h1",
+		Status:      mutationFailed,
+		StatusCode:  http.StatusBadRequest,
+		Response:    &failedResponse,
+	}
+	if w := replay(failed, "h1"); w.Code != http.StatusBadRequest || w.Body.String() != failedResponse {
+		t.Errorf("replay of a failure answered %d %s", w.Code, w.Body)
+	}
+}
+
+func TestReplayMutationConflicts(t *testing.T) {
+	response := `{"rows_affected":1}`
+
+	for _, c := range []struct {
+		prev *entity.MutationLog
+		hash string
+		code int
+	}{
+		{&entity.MutationLog{RequestHash: "h1", Status: mutationSucceeded, StatusCode: 200, Response: &response}, "h2", http.StatusUnprocessableEntity},
+		{&entity.MutationLog{RequestHash: "h1", Status: mutationPending}, "h2", http.StatusUnprocessableEntity},
+		{&entity.MutationLog{RequestHash: "h1", Status: mutationPending}, "h1", http.StatusConflict},
+		{&entity.MutationLog{RequestHash: "h1", Status: mutationSucceeded}, "h1", http.StatusConflict},
+	} {
+		w := replay(c.prev, c.hash)
+		if w.Code != c.code {
+			t.Errorf("%s log with hash %s replayed for %s answered %d, want %d", c.prev.Status, c.prev.RequestHash, c.hash, w.Code, c.code)
+		}
+		if w.Header().Get("Idempotent-Replayed") != "" {
+			t.Errorf("a refused replay is marked replayed")
+		}
+	}
+}
+
+func TestReclaimMutationLogWaitsForTimeout(t *testing.T) {
+	s := &Service{conf: Config{MutationTimeout: time.Minute}}
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+	recent := int(time.Now().Add(-30 * time.Second).Unix())
+	// a log within the timeout is left alone without touching the database
+	reclaimed, err := s.reclaimMutationLog(c, &entity.MutationLog{Status: mutationPending, UpdatedAt: &recent})
+	if err != nil || reclaimed {
+		t.Errorf("a running mutation was taken over: %v, %v", reclaimed, err)
+	}
+
+	reclaimed, err = s.reclaimMutationLog(c, &entity.MutationLog{Status: mutationPending})
+	if err != nil || reclaimed {
+		t.Errorf("a log without an update time was taken over: %v, %v", reclaimed, err)
+	}
+}
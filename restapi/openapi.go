@@ -0,0 +1,250 @@
+package restapi
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var routeParamPattern = regexp.MustCompile(`[:*]([A-Za-z0-9_]+)`)
+
+// OpenAPIInfo is the info section of the generated spec
+type OpenAPIInfo struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+// OpenAPIHandler serves the OpenAPI 3 spec of routes. The server url is
+// relative, so the spec follows the host it is requested from, and the
+// X-Forwarded-Prefix of a proxy.
+func OpenAPIHandler(info OpenAPIInfo, routes []Route) gin.HandlerFunc {
+	schemas := schemaSet{}
+	paths := openAPIPaths(routes, schemas)
+
+	return func(c *gin.Context) {
+		server := "/"
+		if prefix := strings.TrimSuffix(c.GetHeader("X-Forwarded-Prefix"), "/"); prefix != "" {
+			server = prefix + "/"
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"openapi": "3.0.3",
+			"info":    info,
+			"servers": []gin.H{{"url": server}},
+			"paths":   paths,
+			"components": gin.H{
+				"schemas": schemas,
+			},
+		})
+	}
+}
+
+// openAPIPath converts a gin path to the OpenAPI template syntax
+func openAPIPath(path string) string {
+	return routeParamPattern.ReplaceAllString(path, "{$1}")
+}
+
+func openAPIPaths(routes []Route, schemas schemaSet) map[string]map[string]interface{} {
+	paths := map[string]map[string]interface{}{}
+
+	for _, r := range routes {
+		path := openAPIPath(r.Path)
+		if paths[path] == nil {
+			paths[path] = map[string]interface{}{}
+		}
+		paths[path][strings.ToLower(r.Method)] = openAPIOperation(r, schemas)
+	}
+	return paths
+}
+
+func openAPIOperation(r Route, schemas schemaSet) gin.H {
+	op := gin.H{
+		"summary": r.Summary,
+		"tags":    []string{r.Tag},
+	}
+	if r.Description != "" {
+		op["description"] = r.Description
+	}
+
+	var params []gin.H
+	declared := map[string]bool{}
+	var form []Param
+	for _, p := range r.Params {
+		if p.In == "form" {
+			form = append(form, p)
+			continue
+		}
+		declared[p.Name] = true
+		params = append(params, openAPIParam(p))
+	}
+	// path params are always documented, even when they are not declared
+	for _, m := range routeParamPattern.FindAllStringSubmatch(r.Path, -1) {
+		if !declared[m[1]] {
+			params = append(params, openAPIParam(Param{Name: m[1], In: "path"}))
+		}
+	}
+	if len(params) > 0 {
+		op["parameters"] = params
+	}
+
+	if len(form) > 0 {
+		props := gin.H{}
+		var required []string
+		for _, p := range form {
+			props[p.Name] = gin.H{"type": paramType(p), "description": p.Description}
+			if p.Required {
+				required = append(required, p.Name)
+			}
+		}
+		formSchema := gin.H{"type": "object", "properties": props}
+		if len(required) > 0 {
+			formSchema["required"] = required
+		}
+		op["requestBody"] = gin.H{
+			"required": true,
+			"content": gin.H{
+				"application/x-www-form-urlencoded": gin.H{"schema": formSchema},
+			},
+		}
+	} else if r.Body != nil {
+		op["requestBody"] = gin.H{
+			"required": true,
+			"content": gin.H{
+				"application/json": gin.H{"schema": schemas.of(reflect.TypeOf(r.Body))},
+			},
+		}
+	}
+
+	status := r.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	responses := gin.H{
+		strconv.Itoa(status): openAPIResponse(r.Response, r.ResponseType, schemas),
+	}
+	for _, code := range r.Errors {
+		responses[strconv.Itoa(code)] = gin.H{
+			"description": http.StatusText(code),
+			"content": gin.H{
+				"application/json": gin.H{"schema": schemas.of(reflect.TypeOf(Error{}))},
+			},
+		}
+	}
+	op["responses"] = responses
+
+	return op
+}
+
+func openAPIResponse(response interface{}, contentType string, schemas schemaSet) gin.H {
+	res := gin.H{"description": "OK"}
+	if response == nil {
+		return res
+	}
+
+	if contentType == "" {
+		contentType = "application/json"
+		if _, ok := response.(string); ok {
+			contentType = "text/plain"
+		}
+	}
+	res["content"] = gin.H{
+		contentType: gin.H{"schema": schemas.of(reflect.TypeOf(response))},
+	}
+	return res
+}
+
+func openAPIParam(p Param) gin.H {
+	param := gin.H{
+		"name":     p.Name,
+		"in":       p.In,
+		"required": p.Required || p.In == "path",
+		"schema":   gin.H{"type": paramType(p)},
+	}
+	if p.Description != "" {
+		param["description"] = p.Description
+	}
+	return param
+}
+
+func paramType(p Param) string {
+	if p.Type == "" {
+		return "string"
+	}
+	return p.Type
+}
+
+// schemaSet collects the schemas of named struct types, referenced as
+// #/components/schemas/<package>.<Type>
+type schemaSet map[string]interface{}
+
+func (set schemaSet) of(t reflect.Type) gin.H {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return set.of(t.Elem())
+	case reflect.Bool:
+		return gin.H{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return gin.H{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return gin.H{"type": "number"}
+	case reflect.String:
+		return gin.H{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return gin.H{"type": "array", "items": set.of(t.Elem())}
+	case reflect.Map:
+		return gin.H{"type": "object", "additionalProperties": set.of(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return set.object(t)
+		}
+		name := t.String()
+		if _, ok := set[name]; !ok {
+			// registered before the fields, for recursive types
+			set[name] = gin.H{}
+			set[name] = set.object(t)
+		}
+		return gin.H{"$ref": "#/components/schemas/" + name}
+	}
+	return gin.H{}
+}
+
+func (set schemaSet) object(t reflect.Type) gin.H {
+	props := gin.H{}
+	set.fields(t, props)
+	return gin.H{"type": "object", "properties": props}
+}
+
+// fields adds the json properties of struct t, embedded structs are inlined
+func (set schemaSet) fields(t reflect.Type, props gin.H) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" && !f.Anonymous {
+			continue
+		}
+
+		tag := f.Tag.Get("json")
+		name := strings.Split(tag, ",")[0]
+		if name == "-" {
+			continue
+		}
+
+		ft := f.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if f.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+			set.fields(ft, props)
+			continue
+		}
+
+		if name == "" {
+			name = f.Name
+		}
+		props[name] = set.of(f.Type)
+	}
+}
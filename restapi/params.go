@@ -0,0 +1,277 @@
+package restapi
+
+import (
+	"fmt"
+	"github.com/wangxb07/sqlcomposer"
+	"gitlab.com/beehplus/sql-compose/sqlident"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	paramDateLayout     = "2006-01-02"
+	paramDatetimeLayout = "2006-01-02 15:04:05"
+)
+
+// paramPlaceholderPattern matches :name, and the :: sqlx escapes a colon with
+var paramPlaceholderPattern = regexp.MustCompile(`::|:[A-Za-z_][A-Za-z0-9_]*`)
+
+var paramTypes = map[string]bool{
+	"string":   true,
+	"integer":  true,
+	"number":   true,
+	"boolean":  true,
+	"date":     true,
+	"datetime": true,
+}
+
+// ParamSpec declares a named parameter of a doc. The subject sql refers to
+// it as :name, it is bound from the params of the request or the default.
+// Params without a value are bound as NULL unless required.
+type ParamSpec struct {
+	Type      string        `yaml:"type"`
+	Default   interface{}   `yaml:"default,omitempty"`
+	Required  bool          `yaml:"required,omitempty"`
+	Enum      []interface{} `yaml:"enum,omitempty"`
+	Minimum   *float64      `yaml:"minimum,omitempty"`
+	Maximum   *float64      `yaml:"maximum,omitempty"`
+	MaxLength *int          `yaml:"max_length,omitempty"`
+	Pattern   string        `yaml:"pattern,omitempty"`
+}
+
+// checkDocParams validates the param declarations of a doc before it is
+// saved
+func checkDocParams(params map[string]*ParamSpec) error {
+	for name, p := range params {
+		if !sqlident.Valid(name) {
+			return fmt.Errorf("param name %q is invalid", name)
+		}
+		if p == nil || !paramTypes[p.Type] {
+			return fmt.Errorf("param %s: type must be one of string, integer, number, boolean, date or datetime", name)
+		}
+		if p.Pattern != "" {
+			if _, err := regexp.Compile(p.Pattern); err != nil {
+				return fmt.Errorf("param %s: invalid pattern", name)
+			}
+		}
+		if p.Default != nil {
+			if _, err := p.value(name, p.Default); err != nil {
+				return fmt.Errorf("invalid default: %v", err)
+			}
+		}
+	}
+	return nil
+}
+
+// value converts v, from json, the query string or the doc yaml, to the
+// declared type and validates it
+func (p *ParamSpec) value(name string, v interface{}) (interface{}, error) {
+	var value interface{}
+
+	switch p.Type {
+	case "string":
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("param %s must be a string", name)
+		}
+		if p.MaxLength != nil && len([]rune(s)) > *p.MaxLength {
+			return nil, fmt.Errorf("param %s must be at most %d characters", name, *p.MaxLength)
+		}
+		if p.Pattern != "" {
+			if re, err := regexp.Compile(p.Pattern); err != nil || !re.MatchString(s) {
+				return nil, fmt.Errorf("param %s does not match %s", name, p.Pattern)
+			}
+		}
+		value = s
+	case "integer":
+		i, err := paramInt(v)
+		if err != nil {
+			return nil, fmt.Errorf("param %s must be an integer", name)
+		}
+		if err := p.checkRange(name, float64(i)); err != nil {
+			return nil, err
+		}
+		value = i
+	case "number":
+		f, err := paramFloat(v)
+		if err != nil {
+			return nil, fmt.Errorf("param %s must be a number", name)
+		}
+		if err := p.checkRange(name, f); err != nil {
+			return nil, err
+		}
+		value = f
+	case "boolean":
+		switch b := v.(type) {
+		case bool:
+			value = b
+		case string:
+			parsed, err := strconv.ParseBool(b)
+			if err != nil {
+				return nil, fmt.Errorf("param %s must be a boolean", name)
+			}
+			value = parsed
+		default:
+			return nil, fmt.Errorf("param %s must be a boolean", name)
+		}
+	case "date", "datetime":
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("param %s must be a %s string", name, p.Type)
+		}
+		t, err := parseParamTime(p.Type, s)
+		if err != nil {
+			return nil, fmt.Errorf("param %s must be a %s like %s", name, p.Type, paramTimeLayout(p.Type))
+		}
+		value = t.Format(paramTimeLayout(p.Type))
+	default:
+		return nil, fmt.Errorf("param %s has an unknown type %s", name, p.Type)
+	}
+
+	if len(p.Enum) > 0 {
+		for _, e := range p.Enum {
+			if fmt.Sprint(e) == fmt.Sprint(value) {
+				return value, nil
+			}
+		}
+		return nil, fmt.Errorf("param %s must be one of %v", name, p.Enum)
+	}
+	return value, nil
+}
+
+func (p *ParamSpec) checkRange(name string, f float64) error {
+	if p.Minimum != nil && f < *p.Minimum {
+		return fmt.Errorf("param %s must be >= %v", name, *p.Minimum)
+	}
+	if p.Maximum != nil && f > *p.Maximum {
+		return fmt.Errorf("param %s must be <= %v", name, *p.Maximum)
+	}
+	return nil
+}
+
+func paramInt(v interface{}) (int64, error) {
+	switch n := v.(type) {
+	case int:
+		return int64(n), nil
+	case int64:
+		return n, nil
+	case float64:
+		if n != float64(int64(n)) {
+			return 0, fmt.Errorf("not an integer")
+		}
+		return int64(n), nil
+	case string:
+		return strconv.ParseInt(n, 10, 64)
+	}
+	return 0, fmt.Errorf("not an integer")
+}
+
+func paramFloat(v interface{}) (float64, error) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), nil
+	case int64:
+		return float64(n), nil
+	case float64:
+		return n, nil
+	case string:
+		return strconv.ParseFloat(n, 64)
+	}
+	return 0, fmt.Errorf("not a number")
+}
+
+func paramTimeLayout(t string) string {
+	if t == "date" {
+		return paramDateLayout
+	}
+	return paramDatetimeLayout
+}
+
+func parseParamTime(t string, s string) (time.Time, error) {
+	if t == "datetime" {
+		if parsed, err := time.Parse(time.RFC3339, s); err == nil {
+			return parsed, nil
+		}
+	}
+	return time.Parse(paramTimeLayout(t), s)
+}
+
+// bindDocParams binds the declared params of a doc to the builder. Each
+// :name in the subject sql is renamed to a placeholder that no filter uses,
+// so it must run after the filters and tokens are added. Quoted strings,
+// quoted identifiers and comments are left as they are.
+func bindDocParams(sb *sqlcomposer.SqlBuilder, params map[string]*ParamSpec, values map[string]interface{}) error {
+	for name := range values {
+		if _, ok := params[name]; !ok {
+			return fmt.Errorf("param %s is not declared by the doc", name)
+		}
+	}
+
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	if sb.Conditions.Arg == nil {
+		sb.Conditions.Arg = map[string]interface{}{}
+	}
+
+	args := make(map[string]string, len(names))
+	for _, name := range names {
+		p := params[name]
+
+		v, ok := values[name]
+		if !ok || v == nil {
+			v = p.Default
+		}
+
+		var value interface{}
+		if v == nil {
+			if p.Required {
+				return fmt.Errorf("param %s is required", name)
+			}
+		} else {
+			var err error
+			if value, err = p.value(name, v); err != nil {
+				return err
+			}
+		}
+
+		arg := "param_" + name
+		for i := 1; ; i++ {
+			if _, taken := sb.Conditions.Arg[arg]; !taken {
+				break
+			}
+			arg = fmt.Sprintf("param_%s_%d", name, i)
+		}
+		sb.Conditions.Arg[arg] = value
+		args[name] = arg
+	}
+
+	for key, subject := range sb.Doc.Composition.Subject {
+		segments, err := sqlSegments(subject)
+		if err != nil {
+			return fmt.Errorf("%s: %v", key, err)
+		}
+
+		var bound strings.Builder
+		for _, seg := range segments {
+			if !seg.code {
+				bound.WriteString(seg.text)
+				continue
+			}
+			bound.WriteString(paramPlaceholderPattern.ReplaceAllStringFunc(seg.text, func(m string) string {
+				if arg, ok := args[m[1:]]; ok {
+					return ":" + arg
+				}
+				return m
+			}))
+		}
+		sb.Doc.Composition.Subject[key] = bound.String()
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package restapi
+
+import (
+	"github.com/wangxb07/sqlcomposer"
+	"testing"
+)
+
+func floatPtr(f float64) *float64 { return &f }
+
+func intPtr(n int) *int { return &n }
+
+func TestParamValue(t *testing.T) {
+	cases := []struct {
+		spec ParamSpec
+		in   interface{}
+		want interface{}
+	}{
+		{ParamSpec{Type: "string"}, "x' OR '1'='1", "x' OR '1'='1"},
+		{ParamSpec{Type: "string", MaxLength: intPtr(3)}, "héé", "héé"},
+		{ParamSpec{Type: "string", Pattern: `^[a-z]+$`}, "abc", "abc"},
+		{ParamSpec{Type: "integer"}, "42", int64(42)},
+		{ParamSpec{Type: "integer"}, float64(42), int64(42)},
+		{ParamSpec{Type: "integer"}, 42, int64(42)},
+		{ParamSpec{Type: "integer", Minimum: floatPtr(1), Maximum: floatPtr(10)}, "10", int64(10)},
+		{ParamSpec{Type: "number"}, "1.5", 1.5},
+		{ParamSpec{Type: "number"}, int64(2), float64(2)},
+		{ParamSpec{Type: "boolean"}, "true", true},
+		{ParamSpec{Type: "boolean"}, false, false},
+		{ParamSpec{Type: "date"}, "2020-02-29", "2020-02-29"},
+		{ParamSpec{Type: "datetime"}, "2020-06-01 10:00:00", "2020-06-01 10:00:00"},
+		{ParamSpec{Type: "datetime"}, "2020-06-01T10:00:00Z", "2020-06-01 10:00:00"},
+		{ParamSpec{Type: "string", Enum: []interface{}{"paid", "shipped"}}, "paid", "paid"},
+		{ParamSpec{Type: "integer", Enum: []interface{}{1, 2}}, "2", int64(2)},
+	}
+
+	for _, c := range cases {
+		got, err := c.spec.value("p", c.in)
+		if err != nil {
+			t.Errorf("%s %#v: %v", c.spec.Type, c.in, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("%s %#v is %#v, want %#v", c.spec.Type, c.in, got, c.want)
+		}
+	}
+}
+
+func TestParamValueRejects(t *testing.T) {
+	cases := []struct {
+		spec ParamSpec
+		in   interface{}
+	}{
+		{ParamSpec{Type: "string"}, 1},
+		{ParamSpec{Type: "string", MaxLength: intPtr(3)}, "abcd"},
+		{ParamSpec{Type: "string", Pattern: `^[a-z]+$`}, "abc1"},
+		{ParamSpec{Type: "integer"}, "1.5"},
+		{ParamSpec{Type: "integer"}, 1.5},
+		{ParamSpec{Type: "integer"}, "1; DROP TABLE doc"},
+		{ParamSpec{Type: "integer"}, true},
+		{ParamSpec{Type: "integer", Minimum: floatPtr(1)}, "0"},
+		{ParamSpec{Type: "integer", Maximum: floatPtr(10)}, "11"},
+		{ParamSpec{Type: "number"}, "NaN-ish"},
+		{ParamSpec{Type: "boolean"}, "yes"},
+		{ParamSpec{Type: "boolean"}, 1},
+		{ParamSpec{Type: "date"}, "2020-02-30"},
+		{ParamSpec{Type: "date"}, "01/06/2020"},
+		{ParamSpec{Type: "datetime"}, "2020-06-01"},
+		{ParamSpec{Type: "date"}, 20200601},
+		{ParamSpec{Type: "string", Enum: []interface{}{"paid", "shipped"}}, "void"},
+		{ParamSpec{Type: "object"}, "x"},
+	}
+
+	for _, c := range cases {
+		if got, err := c.spec.value("p", c.in); err == nil {
+			t.Errorf("%s %#v was accepted as %#v", c.spec.Type, c.in, got)
+		}
+	}
+}
+
+func TestCheckDocParams(t *testing.T) {
+	valid := map[string]*ParamSpec{
+		"shop":  {Type: "integer", Default: 1},
+		"since": {Type: "date", Required: true},
+	}
+	if err := checkDocParams(valid); err != nil {
+		t.Errorf("valid params were rejected: %v", err)
+	}
+
+	for _, params := range []map[string]*ParamSpec{
+		{"a b": {Type: "string"}},
+		{"a": nil},
+		{"a": {Type: "text"}},
+		{"a": {Type: "string", Pattern: "("}},
+		{"a": {Type: "integer", Default: "one"}},
+	} {
+		if err := checkDocParams(params); err == nil {
+			t.Errorf("params %v were accepted", params)
+		}
+	}
+}
+
+func paramBuilder(t *testing.T, subject string) *sqlcomposer.SqlBuilder {
+	t.Helper()
+
+	sb, err := sqlcomposer.NewSqlBuilder(nil, []byte("composition:\n  subject:\n    subject: \"\"\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sb.Doc.Composition.Subject["subject"] = subject
+	return sb
+}
+
+func TestBindDocParams(t *testing.T) {
+	params := map[string]*ParamSpec{
+		"shop":  {Type: "integer"},
+		"since": {Type: "date", Default: "2020-01-01"},
+		"note":  {Type: "string"},
+	}
+
+	sb := paramBuilder(t, "SELECT * FROM t WHERE shop_id = :shop AND placed >= :since AND note = :note AND :shopping")
+	// a filter already took param_shop
+	sb.Conditions.Arg = map[string]interface{}{"param_shop": "filter"}
+
+	if err := bindDocParams(sb, params, map[string]interface{}{"shop": "7"}); err != nil {
+		t.Fatalf("bindDocParams: %v", err)
+	}
+
+	want := "SELECT * FROM t WHERE shop_id = :param_shop_1 AND placed >= :param_since AND note = :param_note AND :shopping"
+	if got := sb.Doc.Composition.Subject["subject"]; got != want {
+		t.Errorf("subject is\n%s\nwant\n%s", got, want)
+	}
+	for arg, v := range map[string]interface{}{"param_shop": "filter", "param_shop_1": int64(7), "param_since": "2020-01-01", "param_note": nil} {
+		if got, ok := sb.Conditions.Arg[arg]; !ok || got != v {
+			t.Errorf("%s is bound to %#v, want %#v", arg, got, v)
+		}
+	}
+}
+
+func TestBindDocParamsSkipsLiteralsAndComments(t *testing.T) {
+	params := map[string]*ParamSpec{"shop": {Type: "integer", Default: 1}}
+
+	sb := paramBuilder(t, "SELECT ':shop', `:shop`, \":shop\", 'it''s :shop' AS x, '::shop' FROM t -- :shop\n"+
+		"WHERE /* :shop */ shop_id = :shop # :shop")
+	if err := bindDocParams(sb, params, nil); err != nil {
+		t.Fatalf("bindDocParams: %v", err)
+	}
+
+	want := "SELECT ':shop', `:shop`, \":shop\", 'it''s :shop' AS x, '::shop' FROM t -- :shop\n" +
+		"WHERE /* :shop */ shop_id = :param_shop # :shop"
+	if got := sb.Doc.Composition.Subject["subject"]; got != want {
+		t.Errorf("subject is\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestBindDocParamsRejects(t *testing.T) {
+	params := map[string]*ParamSpec{"shop": {Type: "integer", Required: true}}
+
+	for _, values := range []map[string]interface{}{
+		nil,
+		{"shop": nil},
+		{"shop": "seven"},
+		{"shop": 1, "other": 2},
+	} {
+		sb := paramBuilder(t, "SELECT * FROM t WHERE shop_id = :shop")
+		if err := bindDocParams(sb, params, values); err == nil {
+			t.Errorf("params %v were accepted", values)
+		}
+	}
+
+	sb := paramBuilder(t, "SELECT * FROM t WHERE note = 'unterminated AND shop_id = :shop")
+	if err := bindDocParams(sb, params, map[string]interface{}{"shop": 1}); err == nil {
+		t.Errorf("an unterminated literal was accepted")
+	}
+}
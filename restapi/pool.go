@@ -0,0 +1,200 @@
+package restapi
+
+import (
+	"context"
+	"database/sql"
+	"github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+	"sync"
+	"time"
+)
+
+// dialTimeout bounds connecting to a target database whose dsn sets no
+// timeout
+const dialTimeout = 5 * time.Second
+
+// dbPool keeps one connection pool per target database config, so requests
+// stop dialing the target database every time. A pool is replaced when the
+// dsn of its config changes. Dialing happens outside the lock, requests for
+// the same config wait for one dial and requests for others are not held up.
+//
+// A replaced or closed pool is retired: later requests get a new one, while
+// the requests still using it keep it until they release it, and the last of
+// them closes it.
+type dbPool struct {
+	mu    sync.Mutex
+	conns map[string]*pooledDB
+}
+
+type pooledDB struct {
+	dsn string
+	// ready is closed once db or err is set
+	ready chan struct{}
+	db    *sqlx.DB
+	err   error
+	// users is the number of requests holding the pool
+	users   int
+	retired bool
+}
+
+func newDbPool() *dbPool {
+	return &dbPool{
+		conns: map[string]*pooledDB{},
+	}
+}
+
+// GetContext returns the pool of the config name, dialing it when needed,
+// and a func the caller must call once it is done with the pool. The caller
+// stops waiting for the dial when ctx is done.
+func (p *dbPool) GetContext(ctx context.Context, name string, dsn string) (*sqlx.DB, func(), error) {
+	p.mu.Lock()
+	c, ok := p.conns[name]
+	if ok && c.dsn != dsn {
+		p.retireLocked(name, c)
+		ok = false
+	}
+	if !ok {
+		c = &pooledDB{dsn: dsn, ready: make(chan struct{})}
+		p.conns[name] = c
+		go p.dial(name, c)
+	}
+	c.users++
+	p.mu.Unlock()
+
+	release := func() { p.release(c) }
+
+	select {
+	case <-c.ready:
+		if c.err != nil {
+			release()
+			return nil, nil, c.err
+		}
+		return c.db, release, nil
+	case <-ctx.Done():
+		release()
+		return nil, nil, ctx.Err()
+	}
+}
+
+// dial connects c, a failed dial is forgotten so the next request retries
+func (p *dbPool) dial(name string, c *pooledDB) {
+	db, err := connectTarget(c.dsn)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	defer close(c.ready)
+
+	if err != nil && p.conns[name] == c {
+		delete(p.conns, name)
+	}
+	c.db, c.err = db, err
+	if c.retired && c.users == 0 && db != nil {
+		// retired while dialing and nobody waits for it
+		db.Close()
+	}
+}
+
+func (p *dbPool) release(c *pooledDB) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	c.users--
+	if c.retired && c.users == 0 {
+		select {
+		case <-c.ready:
+			if c.db != nil {
+				c.db.Close()
+			}
+		default:
+			// dial closes it
+		}
+	}
+}
+
+// connectTarget opens and pings a target database within the timeout of its
+// dsn, or dialTimeout
+func connectTarget(dsn string) (*sqlx.DB, error) {
+	cfg, err := targetConfig(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeout)
+	defer cancel()
+	return sqlx.ConnectContext(ctx, "mysql", cfg.FormatDSN())
+}
+
+// pingTarget checks dsn on a connection of its own, bound by ctx, so a
+// check neither waits for the pool nor leaves a connection behind
+func pingTarget(ctx context.Context, dsn string) error {
+	cfg, err := targetConfig(dsn)
+	if err != nil {
+		return err
+	}
+	// the handshake is only bound by the timeouts of the driver
+	if deadline, ok := ctx.Deadline(); ok {
+		cfg.Timeout = time.Until(deadline)
+		cfg.ReadTimeout, cfg.WriteTimeout = cfg.Timeout, cfg.Timeout
+	}
+
+	db, err := sql.Open("mysql", cfg.FormatDSN())
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	db.SetMaxIdleConns(0)
+	return db.PingContext(ctx)
+}
+
+// targetConfig is the read only driver config of a target dsn
+func targetConfig(dsn string) (*mysql.Config, error) {
+	safeDSN, err := readOnlyDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := mysql.ParseDSN(safeDSN)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = dialTimeout
+	}
+	return cfg, nil
+}
+
+// retireLocked takes the pool of c out of use and closes it once it has no
+// users, or lets its last user or its dial close it
+func (p *dbPool) retireLocked(name string, c *pooledDB) {
+	delete(p.conns, name)
+	c.retired = true
+	if c.users > 0 {
+		return
+	}
+	select {
+	case <-c.ready:
+		if c.db != nil {
+			c.db.Close()
+		}
+	default:
+	}
+}
+
+// Close the pool of one config, e.g. after it was changed or deleted
+func (p *dbPool) Close(name string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if c, ok := p.conns[name]; ok {
+		p.retireLocked(name, c)
+	}
+}
+
+// CloseAll closes the pools of every config, e.g. on shutdown
+func (p *dbPool) CloseAll() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for name, c := range p.conns {
+		p.retireLocked(name, c)
+	}
+}
@@ -0,0 +1,95 @@
+package restapi
+
+import (
+	"context"
+	"github.com/jmoiron/sqlx"
+	"strings"
+	"testing"
+)
+
+const unreachableDSN = "u:p@tcp(127.0.0.1:1)/db?timeout=1s"
+
+// dialedPool puts a pool of dsn under name as if it was dialed. sql.Open
+// does not connect, so closing it can be told apart by a ping.
+func dialedPool(t *testing.T, p *dbPool, name string, dsn string) *pooledDB {
+	t.Helper()
+
+	db, err := sqlx.Open("mysql", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &pooledDB{dsn: dsn, ready: make(chan struct{}), db: db}
+	close(c.ready)
+	p.conns[name] = c
+	return c
+}
+
+func isClosed(db *sqlx.DB) bool {
+	err := db.PingContext(context.Background())
+	return err != nil && strings.Contains(err.Error(), "database is closed")
+}
+
+func TestDbPoolCloseWaitsForUsers(t *testing.T) {
+	p := newDbPool()
+	c := dialedPool(t, p, "shop", unreachableDSN)
+
+	db, release, err := p.GetContext(context.Background(), "shop", unreachableDSN)
+	if err != nil {
+		t.Fatalf("GetContext: %v", err)
+	}
+	if db != c.db {
+		t.Fatalf("GetContext dialed again")
+	}
+
+	p.Close("shop")
+	if _, ok := p.conns["shop"]; ok {
+		t.Errorf("a closed pool is still handed out")
+	}
+	if isClosed(db) {
+		t.Fatalf("the pool was closed under its user")
+	}
+
+	release()
+	if !isClosed(db) {
+		t.Errorf("the pool was not closed by its last user")
+	}
+}
+
+func TestDbPoolReplacesChangedDSN(t *testing.T) {
+	p := newDbPool()
+	old := dialedPool(t, p, "shop", unreachableDSN)
+
+	_, release, err := p.GetContext(context.Background(), "shop", unreachableDSN)
+	if err != nil {
+		t.Fatalf("GetContext: %v", err)
+	}
+
+	changed := unreachableDSN + "&readTimeout=1s"
+	if _, _, err := p.GetContext(context.Background(), "shop", changed); err == nil {
+		t.Errorf("an unreachable database was dialed")
+	}
+	if !old.retired || isClosed(old.db) {
+		t.Fatalf("the replaced pool was closed under its user")
+	}
+
+	release()
+	if !isClosed(old.db) {
+		t.Errorf("the replaced pool was not closed by its last user")
+	}
+	if old.users != 0 {
+		t.Errorf("the replaced pool has %d users", old.users)
+	}
+}
+
+func TestDbPoolCloseUnused(t *testing.T) {
+	p := newDbPool()
+	c := dialedPool(t, p, "shop", unreachableDSN)
+
+	p.Close("shop")
+	if !isClosed(c.db) {
+		t.Errorf("an unused pool was not closed")
+	}
+
+	// a config without a pool
+	p.Close("none")
+}
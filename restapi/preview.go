@@ -0,0 +1,129 @@
+package restapi
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+	"github.com/wangxb07/sqlcomposer"
+	"gitlab.com/beehplus/sql-compose/entity"
+	"gopkg.in/yaml.v2"
+	"net/http"
+	"strings"
+)
+
+const (
+	// previewPath stands for the path of an unsaved doc, it is never writable
+	previewPath = "(preview)"
+
+	defaultPreviewRows = 10
+	maxPreviewRows     = 100
+
+	explainKeyPrefix = "explain."
+)
+
+// PreviewDoc runs an unsaved doc against a target database, in read only
+// transactions, and answers the rendered sql, args, plans and first rows.
+//
+// The doc comes from the caller, so its security and masking sections are
+// the caller's own and protect nothing: a preview reads anything the target
+// database user can read. It is only served to authenticated callers whose
+// role is one of Config.PreviewRoles, the same people trusted to save docs.
+func (s *Service) PreviewDoc(c *gin.Context) {
+	if !s.conf.previewer(callerOf(c)) {
+		c.JSON(http.StatusForbidden, Error{
+			Code:    40303,
+			Message: "preview is not allowed for this caller",
+		})
+		return
+	}
+
+	var req PreviewRequest
+	if err := c.BindJSON(&req); err != nil {
+		log.Error(err)
+		c.JSON(http.StatusBadRequest, Error{
+			Code:    40006,
+			Message: "preview params error",
+		})
+		return
+	}
+
+	var doc sqlcomposer.SqlApiDoc
+	if err := yaml.Unmarshal([]byte(req.Content), &doc); err != nil {
+		c.JSON(http.StatusBadRequest, Error{
+			Code:    40007,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	spec, err := parseDocSpec([]byte(req.Content))
+	if err == nil {
+		err = checkDocStatements(&doc, spec, false)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Error{
+			Code:    40017,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	if req.PageIndex < 1 {
+		req.PageIndex = 1
+	}
+	if req.PageLimit < 1 {
+		req.PageLimit = defaultPreviewRows
+	}
+	if req.PageLimit > maxPreviewRows {
+		req.PageLimit = maxPreviewRows
+	}
+
+	docEntity := entity.Doc{
+		Content: &req.Content,
+		DB:      req.DbName,
+	}
+	s.serveResult(c, previewPath, &docEntity, &req.GetResultRequest, false, resultPreview)
+}
+
+// servePreview explains the queries of a preview and answers them with the
+// result
+func (s *Service) servePreview(c *gin.Context, db *sqlx.DB, queries []compositionQuery, result *GetResultResponse) {
+	explains := make([]compositionQuery, len(queries))
+	for i, q := range queries {
+		explains[i] = compositionQuery{
+			Key:   explainKeyPrefix + q.Key,
+			Query: "EXPLAIN " + q.Query,
+			Args:  q.Args,
+		}
+	}
+
+	ctx, cancel := s.queryContext(c.Request.Context())
+	defer cancel()
+
+	plans, err := runCompositions(ctx, db, explains, true)
+	if err != nil {
+		log.Error(err)
+		c.JSON(http.StatusBadRequest, Error{
+			Code:    40022,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	preview := PreviewResult{
+		SQL:        result.SQL,
+		Args:       make(map[string][]interface{}, len(queries)),
+		Explain:    make(map[string][]interface{}, len(plans)),
+		Total:      result.Total,
+		Data:       result.Data,
+		NextCursor: result.NextCursor,
+	}
+	for _, q := range queries {
+		preview.Args[q.Key] = q.Args
+	}
+	for _, plan := range plans {
+		preview.Explain[strings.TrimPrefix(plan.Key, explainKeyPrefix)] = plan.Rows
+	}
+
+	c.JSON(http.StatusOK, preview)
+}
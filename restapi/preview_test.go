@@ -0,0 +1,48 @@
+package restapi
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func servePreviewAs(s *Service, caller *Caller) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/doc/preview", func(c *gin.Context) {
+		c.Set(callerKey, caller)
+	}, s.PreviewDoc)
+
+	// the body is not valid, a caller let through is answered 400
+	req := httptest.NewRequest(http.MethodPost, "/doc/preview", strings.NewReader("{"))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestPreviewDocRequiresPreviewRole(t *testing.T) {
+	s := &Service{conf: Config{PreviewRoles: []string{"admin"}}}
+
+	for _, caller := range []*Caller{
+		{},
+		{Role: "admin"},
+		{Subject: "u1"},
+		{Subject: "u1", Role: "viewer"},
+	} {
+		if w := servePreviewAs(s, caller); w.Code != http.StatusForbidden {
+			t.Errorf("caller %+v was answered %d", *caller, w.Code)
+		}
+	}
+
+	if w := servePreviewAs(s, &Caller{Subject: "u1", Role: "admin"}); w.Code != http.StatusBadRequest {
+		t.Errorf("an admin was answered %d", w.Code)
+	}
+
+	s.conf.PreviewRoles = nil
+	if w := servePreviewAs(s, &Caller{Subject: "u1", Role: "admin"}); w.Code != http.StatusForbidden {
+		t.Errorf("preview without roles was answered %d", w.Code)
+	}
+}
@@ -0,0 +1,85 @@
+package restapi
+
+import (
+	"fmt"
+	"github.com/wangxb07/sqlcomposer"
+	"regexp"
+	"strings"
+)
+
+// projectFields narrows every field group of the doc to the requested field
+// names, so only those are rendered by the %fields.* tokens. Groups without a
+// requested field are left out of the select lists. Fields in keep are always
+// retained when declared. An empty request leaves the doc as is.
+func projectFields(doc *sqlcomposer.SqlApiDoc, fields []string, keep ...string) error {
+	if len(fields) == 0 {
+		return nil
+	}
+
+	wanted := map[string]bool{}
+	for _, f := range fields {
+		wanted[f] = true
+	}
+
+	declared := map[string]bool{}
+	for _, group := range doc.Composition.Fields {
+		for _, f := range group {
+			declared[f.Name] = true
+		}
+	}
+
+	for f := range wanted {
+		if !declared[f] {
+			return fmt.Errorf("field %s is not declared in this doc", f)
+		}
+	}
+
+	for _, f := range keep {
+		wanted[f] = true
+	}
+
+	projected := sqlcomposer.SqlCompositionFields{}
+	for name, group := range doc.Composition.Fields {
+		g := sqlcomposer.SqlCompositionFieldGroup{}
+		for _, f := range group {
+			if wanted[f.Name] {
+				g = append(g, f)
+			}
+		}
+
+		if len(g) > 0 {
+			projected[name] = g
+		} else if !dropFieldGroup(doc.Composition.Subject, name) {
+			// the group is the whole select list of a key, it is kept
+			projected[name] = group
+		}
+	}
+
+	doc.Composition.Fields = projected
+	return nil
+}
+
+// dropFieldGroup removes %fields.name with its separating comma from every
+// key of subject. Nothing is removed, and false returned, when a key selects
+// the group alone.
+func dropFieldGroup(subject map[string]string, name string) bool {
+	pattern := regexp.MustCompile(`(,\s*)?%fields\.` + regexp.QuoteMeta(name) + `\b(\s*,)?`)
+
+	for _, s := range subject {
+		for _, m := range pattern.FindAllStringSubmatchIndex(s, -1) {
+			if m[2] < 0 && m[4] < 0 {
+				return false
+			}
+		}
+	}
+
+	for key, s := range subject {
+		subject[key] = pattern.ReplaceAllStringFunc(s, func(token string) string {
+			if strings.HasSuffix(token, ",") && strings.HasPrefix(token, ",") {
+				return ","
+			}
+			return ""
+		})
+	}
+	return true
+}
@@ -0,0 +1,126 @@
+package restapi
+
+import (
+	"context"
+	"database/sql"
+	"github.com/jmoiron/sqlx"
+	"sort"
+	"sync"
+	"time"
+)
+
+// compositionQuery is one rendered composition key of a doc
+type compositionQuery struct {
+	Key   string
+	Query string
+	Args  []interface{}
+	// Sensitive tells the args a log must not show, by position
+	Sensitive []bool
+}
+
+type compositionResult struct {
+	Key   string
+	Total int64
+	Rows  []interface{}
+	// Duration and Err of the query, for the query stats
+	Duration time.Duration
+	Err      error
+}
+
+// runCompositions executes the queries concurrently on db and returns the
+// results in the order of the queries. The first failure cancels the rest
+// and is returned. With readOnly every query runs in its own read only
+// transaction.
+func runCompositions(ctx context.Context, db *sqlx.DB, queries []compositionQuery, readOnly bool) ([]compositionResult, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make([]compositionResult, len(queries))
+
+	var once sync.Once
+	var firstErr error
+
+	var wg sync.WaitGroup
+	for i, q := range queries {
+		wg.Add(1)
+		go func(i int, q compositionQuery) {
+			defer wg.Done()
+
+			start := time.Now()
+			res, err := runComposition(ctx, db, q, readOnly)
+			res.Duration = time.Since(start)
+			res.Err = err
+			if err != nil {
+				once.Do(func() {
+					firstErr = err
+					cancel()
+				})
+			}
+			results[i] = res
+		}(i, q)
+	}
+	wg.Wait()
+
+	return results, firstErr
+}
+
+func runComposition(ctx context.Context, db *sqlx.DB, q compositionQuery, readOnly bool) (res compositionResult, err error) {
+	res.Key = q.Key
+
+	var queryer sqlx.QueryerContext = db
+	if readOnly {
+		tx, err := db.BeginTxx(ctx, &sql.TxOptions{ReadOnly: true})
+		if err != nil {
+			return res, err
+		}
+		defer tx.Rollback()
+		queryer = tx
+	}
+
+	if q.Key == "total" {
+		err = queryer.QueryRowxContext(ctx, q.Query, q.Args...).Scan(&res.Total)
+	} else {
+		res.Rows, err = queryRows(ctx, queryer, q.Query, q.Args)
+	}
+	return res, err
+}
+
+func queryRows(ctx context.Context, queryer sqlx.QueryerContext, query string, args []interface{}) ([]interface{}, error) {
+	rows, err := queryer.QueryxContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var data []interface{}
+	for rows.Next() {
+		item := make(map[string]interface{})
+		if err := rows.MapScan(item); err != nil {
+			return nil, err
+		}
+
+		for k, encoded := range item {
+			switch encoded.(type) {
+			case []byte:
+				item[k] = string(encoded.([]byte))
+			}
+		}
+
+		data = append(data, item)
+	}
+
+	return data, rows.Err()
+}
+
+// compositionKeys returns the keys to run in a stable order
+func compositionKeys(subject map[string]string, withTotal bool) []string {
+	keys := make([]string, 0, len(subject))
+	for key := range subject {
+		if key == "total" && !withTotal {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
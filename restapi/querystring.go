@@ -0,0 +1,201 @@
+package restapi
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/wangxb07/sqlcomposer"
+	"gitlab.com/beehplus/sql-compose/sqlident"
+	"gopkg.in/yaml.v2"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// queryStringSyntax documents the query string of a GET on a doc path
+const queryStringSyntax = "Filters are attr[op]=val, e.g. state[eq]=paid&amount[gte]=100. " +
+	"A bare attr=val means eq. Only the columns the doc selects, sorts or groups by and " +
+	"its filter pipelines are filters, other params (e.g. cache busters) are ignored. " +
+	"Ops: eq, ne, gt, lt, gte, lte, starts_with, contains, " +
+	"ends_with, in, not_in, between, not_between, is_null, is_not_null. " +
+	"in, not_in, between and not_between take comma separated values. " +
+	"Paging and sorting: page_index, page_limit, page_mode, cursor, with_total, " +
+	"fields=a,b and order_by=attr:desc,attr2. Doc params are given as params.name=val."
+
+// queryParamPrefix marks the doc params in the query string
+const queryParamPrefix = "params."
+
+var queryFilterOps = map[string]sqlcomposer.Operator{
+	"eq":          sqlcomposer.Equal,
+	"ne":          sqlcomposer.NotEqual,
+	"gt":          sqlcomposer.Greater,
+	"lt":          sqlcomposer.Less,
+	"gte":         sqlcomposer.GreaterOrEqual,
+	"lte":         sqlcomposer.LessOrEqual,
+	"starts_with": sqlcomposer.StartsWith,
+	"contains":    sqlcomposer.Contains,
+	"ends_with":   sqlcomposer.EndsWith,
+	"in":          sqlcomposer.In,
+	"not_in":      sqlcomposer.NotIn,
+	"between":     sqlcomposer.Between,
+	"not_between": sqlcomposer.NotBetween,
+	"is_null":     sqlcomposer.IsNull,
+	"is_not_null": sqlcomposer.IsNotNull,
+}
+
+var queryFilterPattern = regexp.MustCompile(`^([^\[\]]+)(?:\[([a-z_]+)\])?$`)
+
+// bindResultRequest reads the request of GetResult from the json body, or
+// from the query string of a GET on the doc of content
+func bindResultRequest(c *gin.Context, content []byte, req *GetResultRequest) error {
+	if c.Request.Method == http.MethodGet {
+		return parseResultQuery(c.Request.URL.Query(), queryFilterAttrs(content), req)
+	}
+	return c.BindJSON(req)
+}
+
+// queryFilterAttrs are the attrs a query string may filter on: the columns
+// the fields, sort keys, cursor and dimensions of the doc are made of, and
+// its filter pipelines
+func queryFilterAttrs(content []byte) map[string]bool {
+	attrs := map[string]bool{}
+	add := func(expr string) {
+		if sqlident.ValidQualified(expr) {
+			attrs[expr] = true
+			attrs[expr[strings.LastIndex(expr, ".")+1:]] = true
+		}
+	}
+
+	var doc sqlcomposer.SqlApiDoc
+	if err := yaml.Unmarshal(content, &doc); err == nil {
+		for _, group := range doc.Composition.Fields {
+			for _, f := range group {
+				add(f.Expr)
+			}
+		}
+		for name := range doc.Composition.FilterPipelines {
+			attrs[name] = true
+		}
+	}
+
+	if spec, err := parseDocSpec(content); err == nil {
+		for _, k := range spec.Composition.Sorts.Keys {
+			add(k.Expr)
+		}
+		if spec.Composition.Cursor != nil {
+			add(spec.Composition.Cursor.Expr)
+		}
+		if spec.Composition.Aggregates != nil {
+			for _, d := range spec.Composition.Aggregates.Dimensions {
+				add(d.Expr)
+			}
+		}
+	}
+	return attrs
+}
+
+// parseResultQuery reads a GET request, query params that are neither known
+// nor a filter on one of attrs are ignored
+func parseResultQuery(values url.Values, attrs map[string]bool, req *GetResultRequest) error {
+	var err error
+
+	req.PageIndex = 1
+	if v := values.Get("page_index"); v != "" {
+		if req.PageIndex, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return fmt.Errorf("page_index must be an integer")
+		}
+	}
+	if v := values.Get("page_limit"); v != "" {
+		if req.PageLimit, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return fmt.Errorf("page_limit must be an integer")
+		}
+	}
+	if v := values.Get("with_total"); v != "" {
+		withTotal, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("with_total must be a boolean")
+		}
+		req.WithTotal = &withTotal
+	}
+	req.PageMode = values.Get("page_mode")
+	req.Cursor = values.Get("cursor")
+	req.Fields = splitQueryList(values["fields"])
+
+	for _, item := range splitQueryList(values["order_by"]) {
+		parts := strings.SplitN(item, ":", 2)
+		sortItem := &SortItem{Attr: parts[0]}
+		if len(parts) == 2 {
+			sortItem.Dir = parts[1]
+		}
+		req.OrderBy = append(req.OrderBy, sortItem)
+	}
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		switch key {
+		case "debug", "page_index", "page_limit", "page_mode", "cursor", "with_total", "fields", "order_by":
+			continue
+		}
+
+		if strings.HasPrefix(key, queryParamPrefix) {
+			if req.Params == nil {
+				req.Params = map[string]interface{}{}
+			}
+			req.Params[strings.TrimPrefix(key, queryParamPrefix)] = values.Get(key)
+			continue
+		}
+
+		m := queryFilterPattern.FindStringSubmatch(key)
+		if m == nil || !attrs[m[1]] {
+			continue
+		}
+		attr, opName := m[1], m[2]
+		if opName == "" {
+			opName = "eq"
+		}
+		op, ok := queryFilterOps[opName]
+		if !ok {
+			return fmt.Errorf("filter op %q is not supported", opName)
+		}
+
+		switch op {
+		case sqlcomposer.In, sqlcomposer.NotIn, sqlcomposer.Between, sqlcomposer.NotBetween:
+			req.Filters = append(req.Filters, &GetResultFilterItem{
+				Attr: attr,
+				Op:   op,
+				Val:  splitQueryList(values[key]),
+			})
+		default:
+			for _, v := range values[key] {
+				req.Filters = append(req.Filters, &GetResultFilterItem{
+					Attr: attr,
+					Op:   op,
+					Val:  v,
+				})
+			}
+		}
+	}
+
+	return nil
+}
+
+// splitQueryList reads a list given as repeated params, comma separated
+// values or both
+func splitQueryList(values []string) []string {
+	var list []string
+	for _, v := range values {
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+	}
+	return list
+}
@@ -0,0 +1,139 @@
+package restapi
+
+import (
+	"github.com/wangxb07/sqlcomposer"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+const queryTestDoc = `
+composition:
+  fields:
+    base:
+      - name: order_no
+        expr: o.order_number
+      - name: state
+        expr: state
+      - name: total
+        expr: SUM(o.total_price)
+  filterPipelines:
+    keyword:
+      type: fulltext
+  sorts:
+    keys:
+      - name: create_time
+        expr: placed
+  cursor:
+    attr: order_id
+    expr: order_id
+  aggregates:
+    from: commerce_order
+    dimensions:
+      - name: day
+        expr: DATE(placed)
+      - name: type
+        expr: type
+`
+
+func TestQueryFilterAttrs(t *testing.T) {
+	attrs := queryFilterAttrs([]byte(queryTestDoc))
+
+	for _, attr := range []string{"o.order_number", "order_number", "state", "keyword", "placed", "order_id", "type"} {
+		if !attrs[attr] {
+			t.Errorf("%s is not a filter attr", attr)
+		}
+	}
+	for _, attr := range []string{"order_no", "total", "SUM(o.total_price)", "day", "DATE(placed)", "create_time"} {
+		if attrs[attr] {
+			t.Errorf("%s is a filter attr", attr)
+		}
+	}
+}
+
+func TestParseResultQuery(t *testing.T) {
+	attrs := queryFilterAttrs([]byte(queryTestDoc))
+	values, _ := url.ParseQuery("state=paid&placed[gte]=2020-01-01&o.order_number[in]=a,b&order_number[in]=c" +
+		"&type[between]=1&type[between]=9&state[is_null]=&keyword[contains]=x&keyword[contains]=y" +
+		"&page_index=2&page_limit=20&with_total=false&page_mode=cursor&cursor=abc" +
+		"&fields=order_no,state&fields=total&order_by=create_time:desc,state&params.shop=7&debug=1")
+
+	var req GetResultRequest
+	if err := parseResultQuery(values, attrs, &req); err != nil {
+		t.Fatalf("parseResultQuery: %v", err)
+	}
+
+	if req.PageIndex != 2 || req.PageLimit != 20 || req.WithTotal == nil || *req.WithTotal || req.PageMode != "cursor" || req.Cursor != "abc" {
+		t.Errorf("paging is %+v", req)
+	}
+	if want := []string{"order_no", "state", "total"}; !reflect.DeepEqual(req.Fields, want) {
+		t.Errorf("fields are %v, want %v", req.Fields, want)
+	}
+	if len(req.OrderBy) != 2 || *req.OrderBy[0] != (SortItem{Attr: "create_time", Dir: "desc"}) || *req.OrderBy[1] != (SortItem{Attr: "state"}) {
+		t.Errorf("order is %v", req.OrderBy)
+	}
+	if req.Params["shop"] != "7" || len(req.Params) != 1 {
+		t.Errorf("params are %v", req.Params)
+	}
+
+	// filters come in the order of their sorted keys
+	want := []GetResultFilterItem{
+		{Attr: "keyword", Op: sqlcomposer.Contains, Val: "x"},
+		{Attr: "keyword", Op: sqlcomposer.Contains, Val: "y"},
+		{Attr: "o.order_number", Op: sqlcomposer.In, Val: []string{"a", "b"}},
+		{Attr: "order_number", Op: sqlcomposer.In, Val: []string{"c"}},
+		{Attr: "placed", Op: sqlcomposer.GreaterOrEqual, Val: "2020-01-01"},
+		{Attr: "state", Op: sqlcomposer.Equal, Val: "paid"},
+		{Attr: "state", Op: sqlcomposer.IsNull, Val: ""},
+		{Attr: "type", Op: sqlcomposer.Between, Val: []string{"1", "9"}},
+	}
+	if len(req.Filters) != len(want) {
+		t.Fatalf("got %d filters, want %d", len(req.Filters), len(want))
+	}
+	for i, f := range req.Filters {
+		if !reflect.DeepEqual(*f, want[i]) {
+			t.Errorf("filter %d is %+v, want %+v", i, *f, want[i])
+		}
+	}
+}
+
+func TestParseResultQueryIgnoresUnknownParams(t *testing.T) {
+	attrs := queryFilterAttrs([]byte(queryTestDoc))
+	values, _ := url.ParseQuery("_=1600000000&utm_source=mail&callback=x&total[gt]=1&day=2020-01-01" +
+		"&order_no=1&a[b]c=1&state[eq][x]=1&password=x")
+
+	var req GetResultRequest
+	if err := parseResultQuery(values, attrs, &req); err != nil {
+		t.Fatalf("parseResultQuery: %v", err)
+	}
+	if len(req.Filters) != 0 {
+		for _, f := range req.Filters {
+			t.Errorf("%s was taken as a filter: %+v", f.Attr, *f)
+		}
+	}
+	if req.PageIndex != 1 {
+		t.Errorf("page_index defaults to %d", req.PageIndex)
+	}
+}
+
+func TestParseResultQueryRejects(t *testing.T) {
+	attrs := queryFilterAttrs([]byte(queryTestDoc))
+
+	for _, query := range []string{
+		"state[like]=x",
+		"page_index=first",
+		"page_limit=1.5",
+		"with_total=maybe",
+	} {
+		values, _ := url.ParseQuery(query)
+		if err := parseResultQuery(values, attrs, &GetResultRequest{}); err == nil {
+			t.Errorf("%s was accepted", query)
+		}
+	}
+
+	// an unsupported op on an unknown attr is not a filter
+	values, _ := url.ParseQuery("unknown[like]=x")
+	if err := parseResultQuery(values, attrs, &GetResultRequest{}); err != nil {
+		t.Errorf("unknown[like]: %v", err)
+	}
+}
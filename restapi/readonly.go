@@ -0,0 +1,225 @@
+package restapi
+
+import (
+	"fmt"
+	"github.com/go-sql-driver/mysql"
+	"regexp"
+	"strings"
+)
+
+var sqlTokenPattern = regexp.MustCompile(`[A-Za-z0-9_$@]+|\S`)
+
+// lockingClauses are the clauses a SELECT may end with that write a file or
+// lock rows
+var lockingClauses = [][]string{
+	{"INTO", "OUTFILE"},
+	{"INTO", "DUMPFILE"},
+	{"FOR", "UPDATE"},
+	{"FOR", "SHARE"},
+	{"LOCK", "IN", "SHARE", "MODE"},
+}
+
+// checkReadOnly makes sure query is a single SELECT statement. String
+// literals, quoted identifiers and comments are ignored while checking.
+//
+// Keywords only count where they are keywords: a statement must start with
+// SELECT, or WITH and a SELECT after its common table expressions, and the
+// clauses writing files or locking rows are matched by their words in
+// sequence. Columns named like keywords, e.g. t.load or lock, are fine.
+func checkReadOnly(query string) error {
+	code, err := stripLiterals(query)
+	if err != nil {
+		return err
+	}
+
+	if i := strings.Index(code, ";"); i >= 0 && strings.TrimSpace(code[i+1:]) != "" {
+		return fmt.Errorf("multiple statements are not allowed")
+	}
+
+	tokens := sqlTokenPattern.FindAllString(strings.ToUpper(code), -1)
+	start := 0
+	for start < len(tokens) && tokens[start] == "(" {
+		start++
+	}
+	if start == len(tokens) {
+		return fmt.Errorf("only SELECT statements are allowed")
+	}
+
+	switch tokens[start] {
+	case "SELECT":
+	case "WITH":
+		if !selectsAfterWith(tokens[start+1:]) {
+			return fmt.Errorf("only SELECT statements are allowed")
+		}
+	default:
+		return fmt.Errorf("only SELECT statements are allowed")
+	}
+
+	for i := range tokens {
+		if i > 0 && tokens[i-1] == "." {
+			continue
+		}
+		for _, clause := range lockingClauses {
+			if hasTokens(tokens[i:], clause) {
+				return fmt.Errorf("%s is not allowed in a read only statement", strings.Join(clause, " "))
+			}
+		}
+	}
+	return nil
+}
+
+func hasTokens(tokens []string, prefix []string) bool {
+	if len(tokens) < len(prefix) {
+		return false
+	}
+	for i := range prefix {
+		if tokens[i] != prefix[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// selectsAfterWith reports whether the statement following the common
+// table expressions in tokens, the tokens after WITH, is a SELECT.
+func selectsAfterWith(tokens []string) bool {
+	depth := 0
+	for i, tok := range tokens {
+		switch tok {
+		case "(":
+			depth++
+		case ")":
+			depth--
+			if depth < 0 {
+				return false
+			}
+			if depth > 0 || i+1 == len(tokens) {
+				continue
+			}
+			// the columns of an expression, or one expression of the list
+			if next := tokens[i+1]; next == "AS" || next == "," {
+				continue
+			}
+
+			rest := tokens[i+1:]
+			for len(rest) > 0 && rest[0] == "(" {
+				rest = rest[1:]
+			}
+			return len(rest) > 0 && rest[0] == "SELECT"
+		}
+	}
+	return false
+}
+
+// stripLiterals blanks out quoted strings, quoted identifiers and comments
+func stripLiterals(query string) (string, error) {
+	segments, err := sqlSegments(query)
+	if err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	for _, seg := range segments {
+		switch {
+		case seg.code:
+			sb.WriteString(seg.text)
+		case seg.comment:
+			sb.WriteByte(' ')
+		default:
+			sb.WriteString(" ? ")
+		}
+	}
+	return sb.String(), nil
+}
+
+// sqlSegment is a run of sql code, or one quoted string, quoted identifier
+// or comment
+type sqlSegment struct {
+	text    string
+	code    bool
+	comment bool
+}
+
+// sqlSegments splits query into code and the quoted strings, quoted
+// identifiers and comments in between
+func sqlSegments(query string) ([]sqlSegment, error) {
+	var segments []sqlSegment
+	start := 0
+	literal := func(i int, end int, comment bool) {
+		if start < i {
+			segments = append(segments, sqlSegment{text: query[start:i], code: true})
+		}
+		segments = append(segments, sqlSegment{text: query[i:end], comment: comment})
+		start = end
+	}
+
+	for i := 0; i < len(query); i++ {
+		ch := query[i]
+
+		switch {
+		case ch == '\'' || ch == '"' || ch == '`':
+			end := i + 1
+			for ; end < len(query); end++ {
+				if query[end] == '\\' && ch != '`' {
+					end++
+					continue
+				}
+				if query[end] == ch {
+					if end+1 < len(query) && query[end+1] == ch {
+						end++
+						continue
+					}
+					break
+				}
+			}
+			if end >= len(query) {
+				return nil, fmt.Errorf("unterminated quote in statement")
+			}
+			literal(i, end+1, false)
+			i = end
+		case ch == '#' || (ch == '-' && strings.HasPrefix(query[i:], "-- ")):
+			end := strings.IndexByte(query[i:], '\n')
+			if end < 0 {
+				end = len(query)
+			} else {
+				end += i
+			}
+			literal(i, end, true)
+			i = end - 1
+		case ch == '/' && strings.HasPrefix(query[i:], "/*!"):
+			return nil, fmt.Errorf("executable comments are not allowed")
+		case ch == '/' && strings.HasPrefix(query[i:], "/*"):
+			end := strings.Index(query[i+2:], "*/")
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated comment in statement")
+			}
+			end += i + 4
+			literal(i, end, true)
+			i = end - 1
+		}
+	}
+	if start < len(query) {
+		segments = append(segments, sqlSegment{text: query[start:], code: true})
+	}
+	return segments, nil
+}
+
+// checkDocReadOnly checks every composition key of a doc before it is saved
+func checkDocReadOnly(subject map[string]string) error {
+	for key, s := range subject {
+		if err := checkReadOnly(s); err != nil {
+			return fmt.Errorf("%s: %v", key, err)
+		}
+	}
+	return nil
+}
+
+// readOnlyDSN turns off multi statements on a target dsn
+func readOnlyDSN(dsn string) (string, error) {
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return "", err
+	}
+	cfg.MultiStatements = false
+	return cfg.FormatDSN(), nil
+}
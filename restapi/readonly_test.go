@@ -0,0 +1,54 @@
+package restapi
+
+import (
+	"testing"
+)
+
+func TestCheckReadOnlyAccepts(t *testing.T) {
+	for _, query := range []string{
+		"SELECT * FROM t",
+		"select id from t %where %order_by %limit",
+		"(SELECT 1) UNION (SELECT 2)",
+		"SELECT t.load, t.call, t.handler, t.lock FROM t",
+		"SELECT load, `call`, handler AS h FROM t WHERE lock IN (1, 2)",
+		"SELECT REPLACE(name, 'a', 'b'), INSERT(name, 1, 2, 'x') FROM t",
+		"SELECT * FROM t WHERE note = 'DROP TABLE doc; DELETE FROM t'",
+		"SELECT * FROM t -- UPDATE t SET a = 1\nWHERE id = 1",
+		"SELECT * FROM t /* INTO OUTFILE '/tmp/x' */",
+		"SELECT * FROM `update` WHERE `for` = 'update'",
+		"SELECT * FROM t;",
+		"WITH a AS (SELECT 1) SELECT * FROM a",
+		"WITH RECURSIVE a (n) AS (SELECT 1 UNION ALL SELECT n + 1 FROM a WHERE n < 5), b AS (SELECT 2) (SELECT * FROM a, b)",
+	} {
+		if err := checkReadOnly(query); err != nil {
+			t.Errorf("%q was rejected: %v", query, err)
+		}
+	}
+}
+
+func TestCheckReadOnlyRejects(t *testing.T) {
+	for _, query := range []string{
+		"",
+		"DELETE FROM t",
+		"  (UPDATE t SET a = 1)",
+		"CALL p()",
+		"LOAD DATA INFILE '/etc/passwd' INTO TABLE t",
+		"HANDLER t OPEN",
+		"LOCK TABLES t WRITE",
+		"SELECT 1; DROP TABLE doc",
+		"SELECT * FROM t INTO OUTFILE '/tmp/x'",
+		"SELECT * FROM t into dumpfile '/tmp/x'",
+		"SELECT * FROM t WHERE id = 1 FOR UPDATE",
+		"SELECT * FROM t FOR SHARE",
+		"SELECT * FROM t LOCK IN SHARE MODE",
+		"WITH a AS (SELECT 1) DELETE FROM t",
+		"WITH a AS (SELECT 1) (UPDATE t SET a = 1)",
+		"WITH a AS (SELECT 1)",
+		"SELECT /*! 1; DROP TABLE doc */",
+		"SELECT 'unterminated",
+	} {
+		if err := checkReadOnly(query); err == nil {
+			t.Errorf("%q was accepted", query)
+		}
+	}
+}
@@ -16,6 +16,20 @@ type GetResultRequest struct {
 	PageIndex int64                  `json:"page_index"`
 	PageLimit int64                  `json:"page_limit"`
 	Filters   []*GetResultFilterItem `json:"filters"`
+	Where     *FilterNode            `json:"where"`
+	OrderBy   []*SortItem            `json:"order_by"`
+	PageMode  string                 `json:"page_mode"`
+	Cursor    string                 `json:"cursor"`
+	Fields    []string               `json:"fields"`
+	Aggregate *GetResultAggregate    `json:"aggregate"`
+	WithTotal *bool                  `json:"with_total"`
+	// Params supplies the params declared by the doc
+	Params map[string]interface{} `json:"params"`
+}
+
+type GetResultAggregate struct {
+	Dimensions []string `json:"dimensions"`
+	Measures   []string `json:"measures"`
 }
 
 type GetResultFilterItem struct {
@@ -23,3 +37,11 @@ type GetResultFilterItem struct {
 	Op   sqlcomposer.Operator `json:"op"`
 	Val  interface{}       `json:"val"`
 }
+
+// PreviewRequest is an unsaved doc with the request to run it with. The
+// page limit is capped, 10 rows are returned by default.
+type PreviewRequest struct {
+	Content string `json:"content"`
+	DbName  string `json:"db_name"`
+	GetResultRequest
+}
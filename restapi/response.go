@@ -20,3 +20,93 @@ type Error struct {
   Code    int    `json:"code"`
   Message string `json:"message"`
 }
+
+type AuditLogList struct {
+  Data  []*entity.AuditLog `json:"data"`
+  Total int64              `json:"total"`
+}
+
+type GetResultResponse struct {
+  Total      int64             `json:"total,omitempty"`
+  Data       []interface{}     `json:"data,omitempty"`
+  NextCursor string            `json:"next_cursor,omitempty"`
+  SQL        map[string]string `json:"sql"`
+}
+
+type PreviewResult struct {
+  SQL        map[string]string        `json:"sql"`
+  Args       map[string][]interface{} `json:"args"`
+  Explain    map[string][]interface{} `json:"explain"`
+  Total      int64                    `json:"total,omitempty"`
+  Data       []interface{}            `json:"data"`
+  NextCursor string                   `json:"next_cursor,omitempty"`
+}
+
+// DocDetail is a doc with the warnings of its last plan analysis
+type DocDetail struct {
+  entity.Doc
+  PlanWarnings   []string `json:"plan_warnings,omitempty"`
+  PlanAnalyzedAt *int64   `json:"plan_analyzed_at,omitempty"`
+}
+
+// ExplainResult summarizes the plans of the queries of a doc. Filters whose
+// column is only checked on rows read without an index are listed in
+// UnindexedFilters.
+type ExplainResult struct {
+  SQL              map[string]string `json:"sql"`
+  Plans            []*PlanSummary    `json:"plans"`
+  UnindexedFilters []string          `json:"unindexed_filters"`
+  Warnings         []string          `json:"warnings"`
+  AnalyzedAt       int64             `json:"analyzed_at"`
+}
+
+// PlanSummary is the plan of one composition key, EstimatedRows sums the
+// rows examined per scan of its tables
+type PlanSummary struct {
+  Key            string                 `json:"key"`
+  Tables         []PlanTable            `json:"tables"`
+  FullScans      []string               `json:"full_scans,omitempty"`
+  Filesort       bool                   `json:"filesort"`
+  TemporaryTable bool                   `json:"temporary_table"`
+  EstimatedRows  int64                  `json:"estimated_rows"`
+  Plan           map[string]interface{} `json:"plan"`
+}
+
+type PlanTable struct {
+  Table        string   `json:"table"`
+  AccessType   string   `json:"access_type"`
+  Key          string   `json:"key,omitempty"`
+  PossibleKeys []string `json:"possible_keys,omitempty"`
+  RowsExamined int64    `json:"rows_examined"`
+  Condition    string   `json:"attached_condition,omitempty"`
+}
+
+type QueryStatsList struct {
+  Data  []*entity.QueryStats `json:"data"`
+  Total int64                `json:"total"`
+}
+
+type SlowQueryList struct {
+  Data  []*entity.SlowQuery `json:"data"`
+  Total int64               `json:"total"`
+}
+
+type HealthStatus struct {
+  Status string            `json:"status"`
+  Checks map[string]string `json:"checks,omitempty"`
+}
+
+// DatabaseStatus is the result of pinging one database config
+type DatabaseStatus struct {
+  UUID      *string `json:"uuid,omitempty"`
+  Name      string  `json:"name"`
+  OK        bool    `json:"ok"`
+  LatencyMs float64 `json:"latency_ms"`
+  Error     string  `json:"error,omitempty"`
+}
+
+type DatabaseStatusList struct {
+  Data   []*DatabaseStatus `json:"data"`
+  Total  int64             `json:"total"`
+  Failed int64             `json:"failed"`
+}
@@ -0,0 +1,377 @@
+package restapi
+
+import (
+	"expvar"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+// Route declares an endpoint once, for the gin router and the OpenAPI spec
+type Route struct {
+	Method string
+	// Path in gin syntax, :name and *name params become path params of the spec
+	Path        string
+	Summary     string
+	Description string
+	Tag         string
+	Params      []Param
+	// Body is a value of the json request body type
+	Body interface{}
+	// Status of a successful call, 200 by default
+	Status int
+	// Response is a value of the response type, a string is sent as text
+	Response     interface{}
+	ResponseType string
+	// Errors are the statuses answered with an Error
+	Errors   []int
+	Handlers []gin.HandlerFunc
+	// Via is the path of the route serving this one, when gin can not
+	// register Path next to a :param of Via. The route is documented but not
+	// mounted; Via dispatches to Handlers with dispatchParam.
+	Via string
+}
+
+// Param is a query, header, path or form param of a Route
+type Param struct {
+	Name string
+	// In is query, header, path or form
+	In          string
+	Type        string
+	Required    bool
+	Description string
+}
+
+// Mount registers routes on the router
+func Mount(router gin.IRoutes, routes []Route) {
+	for _, r := range routes {
+		if r.Via == "" {
+			router.Handle(r.Method, r.Path, r.Handlers...)
+		}
+	}
+}
+
+// dispatchParam serves the request with handlers, instead of the rest of the
+// chain, when the path param name is value. The chain is aborted first so a
+// middleware among handlers calling Next does not run the rest of it; one
+// that refuses the request writes its answer, which ends handlers.
+func dispatchParam(name, value string, handlers ...gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Param(name) != value {
+			return
+		}
+		c.Abort()
+		for _, h := range handlers {
+			if c.Writer.Written() {
+				return
+			}
+			h(c)
+		}
+	}
+}
+
+var auditFilterParams = []Param{
+	{Name: "actor", In: "query", Description: "操作人"},
+	{Name: "target", In: "query", Description: "目标uuid"},
+	{Name: "from", In: "query", Type: "integer", Description: "开始时间(unix)"},
+	{Name: "to", In: "query", Type: "integer", Description: "结束时间(unix)"},
+}
+
+// Routes is the route table of the service
+func (s *Service) Routes() []Route {
+	return []Route{
+		{
+			Method:   http.MethodGet,
+			Path:     "/doc",
+			Summary:  "获取文档列表",
+			Tag:      "文档",
+			Response: DocListResult{},
+			Handlers: []gin.HandlerFunc{s.GetDocList},
+		},
+		{
+			Method:  http.MethodPatch,
+			Path:    "/doc",
+			Summary: "添加新的文档",
+			Tag:     "文档",
+			Params: []Param{
+				{Name: "content", In: "form", Required: true, Description: "文档内容"},
+				{Name: "path", In: "form", Required: true, Description: "接口路径"},
+			},
+			Status:   http.StatusCreated,
+			Response: "uuid",
+			Errors:   []int{http.StatusBadRequest},
+			Handlers: []gin.HandlerFunc{s.AddDoc},
+		},
+		{
+			Method:  http.MethodPost,
+			Path:    "/doc",
+			Summary: "添加新的文档基本信息，不包含文档内容",
+			Tag:     "文档",
+			Params: []Param{
+				{Name: "name", In: "form", Required: true, Description: "文档名称"},
+				{Name: "path", In: "form", Required: true, Description: "接口路径"},
+				{Name: "description", In: "form", Required: true, Description: "文档描述"},
+				{Name: "db_name", In: "form", Required: true, Description: "数据库名称"},
+			},
+			Status:   http.StatusCreated,
+			Response: "uuid",
+			Errors:   []int{http.StatusBadRequest},
+			Handlers: []gin.HandlerFunc{s.PostDoc},
+		},
+		{
+			Method:   http.MethodGet,
+			Path:     "/doc/:uuid",
+			Summary:  "获取文档详情",
+			Tag:      "文档",
+			Response: DocDetail{},
+			Handlers: []gin.HandlerFunc{s.GetDocDetailByUuid},
+		},
+		{
+			Method:      http.MethodPost,
+			Path:        "/doc/:uuid/explain",
+			Summary:     "分析文档查询计划",
+			Description: "Runs EXPLAIN FORMAT=JSON on the queries of the doc for the filters, the warnings are shown in the doc detail.",
+			Tag:         "文档",
+			Body:        GetResultRequest{},
+			Response:    ExplainResult{},
+			Errors:      []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusTooManyRequests},
+			Handlers:    []gin.HandlerFunc{s.RateLimit, s.ExplainDoc},
+		},
+		{
+			Method:  http.MethodPost,
+			Path:    "/doc/:uuid",
+			Summary: "更新文档",
+			Tag:     "文档",
+			Params: []Param{
+				{Name: "content", In: "form", Required: true, Description: "文档内容"},
+				{Name: "name", In: "form", Description: "文档名称"},
+				{Name: "path", In: "form", Required: true, Description: "接口路径"},
+				{Name: "description", In: "form", Description: "文档描述"},
+				{Name: "db_name", In: "form", Required: true, Description: "数据库名称"},
+			},
+			Status:   http.StatusCreated,
+			Response: "update completed",
+			Errors:   []int{http.StatusBadRequest},
+			Handlers: []gin.HandlerFunc{
+				dispatchParam("uuid", "preview", s.previewRoute().Handlers...),
+				s.UpdateDoc,
+			},
+		},
+		s.previewRoute(),
+		{
+			Method:   http.MethodDelete,
+			Path:     "/doc/:uuid",
+			Summary:  "删除文档",
+			Tag:      "文档",
+			Status:   http.StatusCreated,
+			Response: "successfully deleted",
+			Handlers: []gin.HandlerFunc{s.DeleteDoc},
+		},
+
+		{
+			Method:   http.MethodGet,
+			Path:     "/dns",
+			Summary:  "数据库配置列表",
+			Tag:      "数据库配置",
+			Response: DbConfigList{},
+			Handlers: []gin.HandlerFunc{s.GetDbConfigList},
+		},
+		{
+			Method:  http.MethodPost,
+			Path:    "/dns",
+			Summary: "添加数据库配置",
+			Tag:     "数据库配置",
+			Params: []Param{
+				{Name: "name", In: "form", Required: true, Description: "数据库名称"},
+				{Name: "dns", In: "form", Required: true, Description: "dsn"},
+			},
+			Status:   http.StatusCreated,
+			Response: "added successfully",
+			Errors:   []int{http.StatusBadRequest},
+			Handlers: []gin.HandlerFunc{s.AddDbConfig},
+		},
+		{
+			Method:   http.MethodPost,
+			Path:     "/dns/:uuid",
+			Summary:  "更新数据库配置",
+			Tag:      "数据库配置",
+			Body:     UpdateDbConfigRequest{},
+			Response: "update completed",
+			Errors:   []int{http.StatusBadRequest},
+			Handlers: []gin.HandlerFunc{s.UpdateDbConfigByUUID},
+		},
+		{
+			Method:   http.MethodDelete,
+			Path:     "/dns/:uuid",
+			Summary:  "删除数据库配置",
+			Tag:      "数据库配置",
+			Status:   http.StatusCreated,
+			Response: "successfully deleted",
+			Handlers: []gin.HandlerFunc{s.DeleteDbConfigByUUID},
+		},
+		{
+			Method:  http.MethodPost,
+			Path:    "/dictionary/refresh",
+			Summary: "刷新字典缓存",
+			Tag:     "数据库配置",
+			Params: []Param{
+				{Name: "db_name", In: "query", Description: "数据库名称，为空时刷新全部"},
+			},
+			Response: "refresh completed",
+			Handlers: []gin.HandlerFunc{s.RefreshDictionaries},
+		},
+
+		{
+			Method:  http.MethodGet,
+			Path:    "/audit",
+			Summary: "审计日志列表",
+			Tag:     "审计",
+			Params: append(auditFilterParams,
+				Param{Name: "page_index", In: "query", Type: "integer", Description: "页码"},
+				Param{Name: "page_limit", In: "query", Type: "integer", Description: "每页条数"},
+			),
+			Response: AuditLogList{},
+			Errors:   []int{http.StatusBadRequest},
+			Handlers: []gin.HandlerFunc{s.GetAuditLogList},
+		},
+		{
+			Method:       http.MethodGet,
+			Path:         "/audit/export",
+			Summary:      "导出审计日志(csv)",
+			Tag:          "审计",
+			Params:       auditFilterParams,
+			Response:     "csv",
+			ResponseType: "text/csv",
+			Errors:       []int{http.StatusBadRequest},
+			Handlers:     []gin.HandlerFunc{s.ExportAuditLog},
+		},
+
+		{
+			Method:   http.MethodGet,
+			Path:     "/healthz",
+			Summary:  "存活检查",
+			Tag:      "监控",
+			Response: HealthStatus{},
+			Handlers: []gin.HandlerFunc{s.Healthz},
+		},
+		{
+			Method:      http.MethodGet,
+			Path:        "/readyz",
+			Summary:     "就绪检查",
+			Description: "Pings the metadata database and checks the docs and the token registry can be loaded.",
+			Tag:         "监控",
+			Response:    HealthStatus{},
+			Errors:      []int{http.StatusServiceUnavailable},
+			Handlers:    []gin.HandlerFunc{s.Readyz},
+		},
+		{
+			Method:      http.MethodGet,
+			Path:        "/status/databases",
+			Summary:     "数据库连接状态",
+			Description: "Pings every database config with a timeout and reports the latency or the error.",
+			Tag:         "监控",
+			Response:    DatabaseStatusList{},
+			Errors:      []int{http.StatusServiceUnavailable},
+			Handlers:    []gin.HandlerFunc{s.GetDatabaseStatus},
+		},
+		{
+			Method:  http.MethodGet,
+			Path:    "/stats/docs",
+			Summary: "最慢的文档查询",
+			Tag:     "监控",
+			Params: []Param{
+				{Name: "limit", In: "query", Type: "integer", Description: "条数，默认20"},
+			},
+			Response: QueryStatsList{},
+			Errors:   []int{http.StatusBadRequest},
+			Handlers: []gin.HandlerFunc{s.GetSlowestDocs},
+		},
+		{
+			Method:  http.MethodGet,
+			Path:    "/stats/slow-queries",
+			Summary: "慢查询日志",
+			Tag:     "监控",
+			Params: []Param{
+				{Name: "path", In: "query", Description: "文档路径"},
+				{Name: "page_index", In: "query", Type: "integer", Description: "页码"},
+				{Name: "page_limit", In: "query", Type: "integer", Description: "每页条数"},
+			},
+			Response: SlowQueryList{},
+			Errors:   []int{http.StatusBadRequest},
+			Handlers: []gin.HandlerFunc{s.GetSlowQueryList},
+		},
+		{
+			Method:   http.MethodGet,
+			Path:     "/debug/vars",
+			Summary:  "运行指标",
+			Tag:      "监控",
+			Response: map[string]interface{}{},
+			Handlers: []gin.HandlerFunc{gin.WrapH(expvar.Handler())},
+		},
+
+		{
+			Method:      http.MethodGet,
+			Path:        s.conf.BasePath + "*path",
+			Summary:     "获取查询结果(查询参数)",
+			Description: queryStringSyntax,
+			Tag:         "接口",
+			Params: []Param{
+				{Name: "path", In: "path", Description: "文档路径"},
+				{Name: "debug", In: "query", Description: "为1时返回sql"},
+				{Name: "page_index", In: "query", Type: "integer", Description: "页码"},
+				{Name: "page_limit", In: "query", Type: "integer", Description: "每页条数"},
+				{Name: "page_mode", In: "query", Description: "为cursor时按游标分页"},
+				{Name: "cursor", In: "query", Description: "游标"},
+				{Name: "with_total", In: "query", Type: "boolean", Description: "是否返回总数"},
+				{Name: "fields", In: "query", Description: "返回字段，逗号分隔"},
+				{Name: "order_by", In: "query", Description: "排序，如 create_time:desc,order_id"},
+			},
+			Response: GetResultResponse{},
+			Errors:   []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusTooManyRequests},
+			Handlers: []gin.HandlerFunc{s.RateLimit, s.GetResult},
+		},
+		{
+			Method:  http.MethodPost,
+			Path:    s.conf.BasePath + "*path",
+			Summary: "获取查询结果",
+			Tag:     "接口",
+			Params: []Param{
+				{Name: "path", In: "path", Description: "文档路径"},
+				{Name: "debug", In: "query", Description: "为1时返回sql"},
+			},
+			Body:     GetResultRequest{},
+			Response: GetResultResponse{},
+			Errors:   []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusTooManyRequests},
+			Handlers: []gin.HandlerFunc{s.RateLimit, s.GetResult},
+		},
+		{
+			Method:  http.MethodPut,
+			Path:    s.conf.BasePath + "*path",
+			Summary: "执行写入文档",
+			Tag:     "接口",
+			Params: []Param{
+				{Name: "path", In: "path", Description: "文档路径"},
+				{Name: "Idempotency-Key", In: "header", Description: "幂等键"},
+			},
+			Body:     map[string]interface{}{},
+			Response: MutationResult{},
+			Errors:   []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusConflict, http.StatusUnprocessableEntity, http.StatusTooManyRequests},
+			Handlers: []gin.HandlerFunc{s.RateLimit, s.Mutate},
+		},
+	}
+}
+
+// previewRoute is served by POST /doc/:uuid, gin 1.6 can not route
+// /doc/preview next to it
+func (s *Service) previewRoute() Route {
+	return Route{
+		Method:   http.MethodPost,
+		Path:     "/doc/preview",
+		Summary:  "预览未保存的文档",
+		Tag:      "文档",
+		Body:     PreviewRequest{},
+		Response: PreviewResult{},
+		Errors:   []int{http.StatusBadRequest, http.StatusForbidden, http.StatusTooManyRequests},
+		Handlers: []gin.HandlerFunc{s.RateLimit, s.PreviewDoc},
+		Via:      "/doc/:uuid",
+	}
+}
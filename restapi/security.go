@@ -0,0 +1,122 @@
+package restapi
+
+import (
+	"fmt"
+	"github.com/wangxb07/sqlcomposer"
+	"gitlab.com/beehplus/sql-compose/sqlident"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	predicatePattern  = regexp.MustCompile(`^\s*([A-Za-z0-9_.]+)\s*(=|!=|<>|(?i:not\s+in)|(?i:in))\s*\{\{\s*claims\.([A-Za-z0-9_]+)\s*\}\}\s*$`)
+	plainWherePattern = regexp.MustCompile(`%where([^\w.{]|$)`)
+	paramWherePattern = regexp.MustCompile(`%where[\w.]*\{`)
+)
+
+// SecuritySpec declares the row level security of a doc. Every predicate,
+// e.g. tenant_id = {{claims.tenant}}, is bound from the claims of the caller
+// and added to the where clause of every composition key.
+type SecuritySpec struct {
+	Predicates []string `yaml:"predicates"`
+}
+
+type rowPredicate struct {
+	attr  string
+	op    sqlcomposer.Operator
+	claim string
+}
+
+func parsePredicate(s string) (rowPredicate, error) {
+	m := predicatePattern.FindStringSubmatch(s)
+	if m == nil {
+		return rowPredicate{}, fmt.Errorf("security predicate %q must look like attr = {{claims.name}}", s)
+	}
+	if !sqlident.ValidQualified(m[1]) {
+		return rowPredicate{}, fmt.Errorf("security predicate attr %q is invalid", m[1])
+	}
+
+	p := rowPredicate{attr: m[1], claim: m[3]}
+	switch strings.ToLower(strings.Join(strings.Fields(m[2]), " ")) {
+	case "=":
+		p.op = sqlcomposer.Equal
+	case "!=", "<>":
+		p.op = sqlcomposer.NotEqual
+	case "in":
+		p.op = sqlcomposer.In
+	case "not in":
+		p.op = sqlcomposer.NotIn
+	}
+	return p, nil
+}
+
+// checkDocSecurity validates the predicates of a doc and that each key of
+// subject applies them
+func checkDocSecurity(spec *SecuritySpec, subject map[string]string) error {
+	if spec == nil || len(spec.Predicates) == 0 {
+		return nil
+	}
+
+	for _, s := range spec.Predicates {
+		if _, err := parsePredicate(s); err != nil {
+			return err
+		}
+	}
+	return checkSecuredSubject(subject)
+}
+
+// checkSecuredSubject requires the plain %where token in every key, the
+// row level predicates are left out by %where{fields}
+func checkSecuredSubject(subject map[string]string) error {
+	keys := make([]string, 0, len(subject))
+	for key := range subject {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if !plainWherePattern.MatchString(subject[key]) {
+			return fmt.Errorf("composition key %s must use %%where to apply the security predicates", key)
+		}
+		if paramWherePattern.MatchString(subject[key]) {
+			return fmt.Errorf("composition key %s can not use %%where{fields} with security predicates", key)
+		}
+	}
+	return nil
+}
+
+// securityConditions binds the predicates of a doc to the claims of the
+// caller. A missing claim denies the request.
+func securityConditions(spec *SecuritySpec, caller *Caller) (sqlcomposer.ConditionStmt, error) {
+	var filters []sqlcomposer.Filter
+	for _, s := range spec.Predicates {
+		p, err := parsePredicate(s)
+		if err != nil {
+			return sqlcomposer.ConditionStmt{}, err
+		}
+
+		v, ok := caller.Claims[p.claim]
+		if !ok || v == nil {
+			return sqlcomposer.ConditionStmt{}, fmt.Errorf("claim %s is required", p.claim)
+		}
+
+		op := p.op
+		if list, ok := v.([]interface{}); ok {
+			if len(list) == 0 {
+				return sqlcomposer.ConditionStmt{}, fmt.Errorf("claim %s is empty", p.claim)
+			}
+			switch op {
+			case sqlcomposer.Equal:
+				op = sqlcomposer.In
+			case sqlcomposer.NotEqual:
+				op = sqlcomposer.NotIn
+			}
+		} else if op == sqlcomposer.In || op == sqlcomposer.NotIn {
+			v = []interface{}{v}
+		}
+
+		filters = append(filters, sqlcomposer.Filter{Attr: p.attr, Op: op, Val: v})
+	}
+	return sqlcomposer.WhereAnd(&filters)
+}
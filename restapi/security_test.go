@@ -0,0 +1,108 @@
+package restapi
+
+import (
+	"github.com/wangxb07/sqlcomposer"
+	"gitlab.com/beehplus/sql-compose/internal/sqltest"
+	"strings"
+	"testing"
+)
+
+func TestParsePredicate(t *testing.T) {
+	for s, want := range map[string]rowPredicate{
+		"tenant_id = {{claims.tenant}}":     {attr: "tenant_id", op: sqlcomposer.Equal, claim: "tenant"},
+		" o.shop_id IN {{ claims.shops }} ": {attr: "o.shop_id", op: sqlcomposer.In, claim: "shops"},
+		"state not  in {{claims.hidden}}":   {attr: "state", op: sqlcomposer.NotIn, claim: "hidden"},
+		"owner <> {{claims.sub}}":           {attr: "owner", op: sqlcomposer.NotEqual, claim: "sub"},
+		"owner != {{claims.sub}}":           {attr: "owner", op: sqlcomposer.NotEqual, claim: "sub"},
+	} {
+		got, err := parsePredicate(s)
+		if err != nil {
+			t.Errorf("%q: %v", s, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("%q parses to %+v, want %+v", s, got, want)
+		}
+	}
+
+	for _, s := range []string{
+		"tenant_id = 1",
+		"tenant_id = {{claims.tenant}} OR 1 = 1",
+		"tenant_id LIKE {{claims.tenant}}",
+		"a.b.c = {{claims.tenant}}",
+		"1a = {{claims.tenant}}",
+		"tenant_id = {{claims.tenant-id}}",
+		"tenant_id = '{{claims.tenant}}'",
+	} {
+		if p, err := parsePredicate(s); err == nil {
+			t.Errorf("%q was accepted as %+v", s, p)
+		}
+	}
+}
+
+func TestSecurityConditionsBindClaims(t *testing.T) {
+	spec := &SecuritySpec{Predicates: []string{
+		"tenant_id = {{claims.tenant}}",
+		"shop_id = {{claims.shops}}",
+		"state != {{claims.hidden}}",
+		"region IN {{claims.region}}",
+		"owner NOT IN {{claims.sub}}",
+	}}
+	caller := &Caller{Subject: "u1", Claims: map[string]interface{}{
+		"tenant": "t' OR '1'='1",
+		"shops":  []interface{}{"s1", "s2"},
+		"hidden": []interface{}{"void"},
+		"region": "eu",
+		"sub":    "u1",
+	}}
+
+	stmt, err := securityConditions(spec, caller)
+	if err != nil {
+		t.Fatalf("securityConditions: %v", err)
+	}
+	sqltest.AssertSafeSQL(t, stmt.Clause, "t' OR '1'='1")
+
+	// list claims turn = into IN and != into NOT IN, single claims of IN
+	// predicates are bound as a list of one
+	for _, part := range []string{"tenant_id =", "shop_id IN", "state NOT IN", "region IN", "owner NOT IN"} {
+		if !strings.Contains(stmt.Clause, part) {
+			t.Errorf("%q does not hold %q", stmt.Clause, part)
+		}
+	}
+	assertBinds(t, markSensitive(stmt), "t' OR '1'='1", "s1", "s2", "void", "eu", "u1")
+}
+
+func TestSecurityConditionsDeny(t *testing.T) {
+	spec := &SecuritySpec{Predicates: []string{"tenant_id = {{claims.tenant}}"}}
+
+	for _, claims := range []map[string]interface{}{
+		nil,
+		{"other": "t1"},
+		{"tenant": nil},
+		{"tenant": []interface{}{}},
+	} {
+		if stmt, err := securityConditions(spec, &Caller{Subject: "u1", Claims: claims}); err == nil {
+			t.Errorf("claims %v were accepted: %q", claims, stmt.Clause)
+		}
+	}
+}
+
+func TestCheckSecuredSubject(t *testing.T) {
+	if err := checkSecuredSubject(map[string]string{
+		"subject": "SELECT * FROM t %where %order_by %limit",
+		"total":   "SELECT COUNT(*) FROM t %where",
+	}); err != nil {
+		t.Errorf("secured subject was rejected: %v", err)
+	}
+
+	for _, subject := range []string{
+		"SELECT * FROM t",
+		"SELECT * FROM t %where{state}",
+		"SELECT * FROM t %where AND id IN (SELECT id FROM u %where{state})",
+		"SELECT * FROM t %where_state",
+	} {
+		if err := checkSecuredSubject(map[string]string{"subject": subject}); err == nil {
+			t.Errorf("%q was accepted", subject)
+		}
+	}
+}
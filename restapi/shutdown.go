@@ -0,0 +1,93 @@
+package restapi
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"sync"
+	"sync/atomic"
+)
+
+// requestCounter counts the requests being handled
+type requestCounter struct {
+	mu     sync.Mutex
+	active int
+	idle   *sync.Cond
+}
+
+func newRequestCounter() *requestCounter {
+	r := &requestCounter{}
+	r.idle = sync.NewCond(&r.mu)
+	return r
+}
+
+func (r *requestCounter) enter() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.active++
+}
+
+func (r *requestCounter) leave() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.active--
+	if r.active == 0 {
+		r.idle.Broadcast()
+	}
+}
+
+func (r *requestCounter) wait() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for r.active > 0 {
+		r.idle.Wait()
+	}
+}
+
+// Track is a middleware counting the requests in flight, for Wait
+func (s *Service) Track(c *gin.Context) {
+	s.requests.enter()
+	defer s.requests.leave()
+	c.Next()
+}
+
+// Wait returns once the handlers of the tracked requests have returned. The
+// server must not accept requests anymore.
+func (s *Service) Wait() {
+	s.requests.wait()
+}
+
+// queryContext derives the context of the queries of a request, it is also
+// done once CancelQueries is called
+func (s *Service) queryContext(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	go func() {
+		select {
+		case <-s.queries.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// Drain turns the readiness false, so no new traffic is routed to the
+// process while the requests in flight finish
+func (s *Service) Drain() {
+	atomic.StoreInt32(&s.draining, 1)
+}
+
+func (s *Service) isDraining() bool {
+	return atomic.LoadInt32(&s.draining) == 1
+}
+
+// CancelQueries cancels the queries still running on the target databases,
+// for requests that outlive the drain timeout
+func (s *Service) CancelQueries() {
+	s.cancelQueries()
+}
+
+// Close closes the connection pools of all target databases, after Wait as
+// the handlers use them. The metadata database is left to its owner.
+func (s *Service) Close() {
+	s.pool.CloseAll()
+}
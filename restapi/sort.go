@@ -0,0 +1,88 @@
+package restapi
+
+import (
+	"fmt"
+	"github.com/wangxb07/sqlcomposer"
+	"strings"
+)
+
+// SortKey maps a client visible sort attr to the sql expression it orders by.
+type SortKey struct {
+	Name string `yaml:"name"`
+	Expr string `yaml:"expr"`
+}
+
+// SortSpec declares how a doc may be ordered. Only attrs listed in Keys are
+// accepted from clients. Default is used when the request has no order_by, and
+// TieBreaker (a unique key) is always appended so offset paging is stable.
+type SortSpec struct {
+	Keys       []SortKey  `yaml:"keys,omitempty"`
+	Default    []SortItem `yaml:"default,omitempty"`
+	TieBreaker string     `yaml:"tiebreaker,omitempty"`
+}
+
+type SortItem struct {
+	Attr string `json:"attr" yaml:"attr"`
+	Dir  string `json:"dir" yaml:"dir"`
+}
+
+func (spec SortSpec) expr(attr string) (string, bool) {
+	for _, k := range spec.Keys {
+		if k.Name == attr {
+			return k.Expr, true
+		}
+	}
+	return "", false
+}
+
+func parseDirection(dir string) (sqlcomposer.Direction, error) {
+	switch strings.ToUpper(dir) {
+	case "", "ASC":
+		return sqlcomposer.ASC, nil
+	case "DESC":
+		return sqlcomposer.DESC, nil
+	}
+	return "", fmt.Errorf("sort direction %s is invalid, use asc or desc", dir)
+}
+
+// buildOrderBy resolves requested sort items against the doc's sort keys.
+// It returns nil when neither the request nor the doc asks for an order.
+func buildOrderBy(spec SortSpec, requested []*SortItem) (sqlcomposer.OrderBy, error) {
+	items := make([]SortItem, 0, len(requested))
+	for _, item := range requested {
+		if item != nil {
+			items = append(items, *item)
+		}
+	}
+	if len(items) == 0 {
+		items = spec.Default
+	}
+
+	var ob sqlcomposer.OrderBy
+	used := map[string]bool{}
+	for _, item := range items {
+		expr, ok := spec.expr(item.Attr)
+		if !ok {
+			return nil, fmt.Errorf("%s is not a sortable attr", item.Attr)
+		}
+		if used[item.Attr] {
+			return nil, fmt.Errorf("%s is sorted more than once", item.Attr)
+		}
+		dir, err := parseDirection(item.Dir)
+		if err != nil {
+			return nil, err
+		}
+		used[item.Attr] = true
+		ob = append(ob, sqlcomposer.Sort{Name: expr, Direction: dir})
+	}
+
+	if spec.TieBreaker != "" && !used[spec.TieBreaker] {
+		expr, ok := spec.expr(spec.TieBreaker)
+		if !ok {
+			return nil, fmt.Errorf("tiebreaker %s is not a declared sort key", spec.TieBreaker)
+		}
+		ob = append(ob, sqlcomposer.Sort{Name: expr, Direction: sqlcomposer.ASC})
+	}
+
+	return ob, nil
+}
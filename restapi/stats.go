@@ -0,0 +1,385 @@
+package restapi
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"github.com/wangxb07/sqlcomposer"
+	"gitlab.com/beehplus/sql-compose/entity"
+	"math"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultStatsWindow = time.Hour
+	// only the most recent samples of a key are kept within the window
+	maxStatSamples = 4096
+	// slow queries waiting to be written, more are dropped
+	slowQueryQueue = 256
+)
+
+type statKey struct {
+	path string
+	key  string
+}
+
+type querySample struct {
+	at       time.Time
+	duration time.Duration
+	rows     int
+	failed   bool
+}
+
+// queryStats keeps the samples of the queries of each doc and composition
+// key run within a rolling window
+type queryStats struct {
+	mu      sync.Mutex
+	window  time.Duration
+	samples map[statKey][]querySample
+}
+
+func newQueryStats(window time.Duration) *queryStats {
+	if window <= 0 {
+		window = defaultStatsWindow
+	}
+	return &queryStats{
+		window:  window,
+		samples: map[statKey][]querySample{},
+	}
+}
+
+func (q *queryStats) record(path string, key string, sample querySample) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	k := statKey{path: path, key: key}
+	samples := append(q.samples[k], sample)
+	if len(samples) > maxStatSamples {
+		samples = samples[len(samples)-maxStatSamples:]
+	}
+	q.samples[k] = samples
+}
+
+// snapshot drops the samples out of the window and summarizes the rest,
+// slowest p95 first
+func (q *queryStats) snapshot(now time.Time) []*entity.QueryStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	since := now.Add(-q.window)
+	var list []*entity.QueryStats
+	for k, samples := range q.samples {
+		i := sort.Search(len(samples), func(i int) bool { return samples[i].at.After(since) })
+		samples = samples[i:]
+		if len(samples) == 0 {
+			delete(q.samples, k)
+			continue
+		}
+		q.samples[k] = samples
+		list = append(list, summarizeSamples(k, samples, q.window, now))
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].P95Ms != list[j].P95Ms {
+			return list[i].P95Ms > list[j].P95Ms
+		}
+		if list[i].DocPath != list[j].DocPath {
+			return list[i].DocPath < list[j].DocPath
+		}
+		return list[i].Key < list[j].Key
+	})
+	return list
+}
+
+func summarizeSamples(k statKey, samples []querySample, window time.Duration, now time.Time) *entity.QueryStats {
+	durations := make([]time.Duration, len(samples))
+	stats := &entity.QueryStats{
+		DocPath:       k.path,
+		Key:           k.key,
+		Count:         int64(len(samples)),
+		WindowSeconds: int64(window.Seconds()),
+		UpdatedAt:     now.Unix(),
+	}
+	for i, s := range samples {
+		durations[i] = s.duration
+		stats.RowsReturned += int64(s.rows)
+		if s.failed {
+			stats.Errors++
+		}
+	}
+	stats.ErrorRate = float64(stats.Errors) / float64(stats.Count)
+
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	stats.P50Ms = durationMs(percentile(durations, 0.5))
+	stats.P95Ms = durationMs(percentile(durations, 0.95))
+	stats.MaxMs = durationMs(durations[len(durations)-1])
+	return stats
+}
+
+// percentile of sorted durations by the nearest rank
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	i := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
+
+func durationMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// recordQueries adds the results of the queries of a doc to the stats and
+// queues those slower than the configured threshold for the slow query log,
+// with their sensitive args redacted. Slow queries are dropped while the
+// queue is full.
+func (s *Service) recordQueries(path string, queries []compositionQuery, results []compositionResult) {
+	now := time.Now()
+	for i, res := range results {
+		sample := querySample{
+			at:       now,
+			duration: res.Duration,
+			rows:     len(res.Rows),
+			failed:   res.Err != nil,
+		}
+		if res.Key == "total" && res.Err == nil {
+			sample.rows = 1
+		}
+		s.stats.record(path, res.Key, sample)
+
+		if s.conf.SlowQuery <= 0 || res.Duration < s.conf.SlowQuery {
+			continue
+		}
+		slow, err := newSlowQuery(path, queries[i], res, now)
+		if err != nil {
+			log.Warn(err)
+			continue
+		}
+		select {
+		case s.slowQueries <- slow:
+		default:
+			s.limits.stats.Add("slow_queries_dropped", 1)
+		}
+	}
+}
+
+func newSlowQuery(path string, q compositionQuery, res compositionResult, at time.Time) (*entity.SlowQuery, error) {
+	encoded, err := json.Marshal(redactArgs(q.Args, q.Sensitive))
+	if err != nil {
+		return nil, err
+	}
+
+	slow := &entity.SlowQuery{
+		DocPath:      path,
+		Key:          q.Key,
+		DurationMs:   durationMs(res.Duration),
+		RowsReturned: int64(len(res.Rows)),
+		Statement:    q.Query,
+		Args:         string(encoded),
+		CreatedAt:    at.Unix(),
+	}
+	if res.Err != nil {
+		message := res.Err.Error()
+		slow.Error = &message
+	}
+	return slow, nil
+}
+
+func (s *Service) logSlowQuery(slow *entity.SlowQuery) {
+	log.WithFields(log.Fields{"path": slow.DocPath, "key": slow.Key, "duration_ms": slow.DurationMs}).Warn("slow query")
+	_, err := s.Db.NamedExec(`INSERT INTO slow_query_log (doc_path, composition_key, duration_ms, rows_returned, statement, args, error, created_at)
+		VALUES (:doc_path, :composition_key, :duration_ms, :rows_returned, :statement, :args, :error, :created_at)`, slow)
+	if err != nil {
+		log.Warn(err)
+	}
+}
+
+// sensitiveArgPrefix marks the named args a log must not show: the claims
+// bound by row level security and the filter values of masked fields
+const sensitiveArgPrefix = "redact_"
+
+// markSensitive renames the args of stmt with sensitiveArgPrefix. The
+// renamed args keep the prefix when conditions are combined.
+func markSensitive(stmt sqlcomposer.ConditionStmt) sqlcomposer.ConditionStmt {
+	return prefixArgs(stmt, sensitiveArgPrefix)
+}
+
+// sensitiveArg stands for a sensitive arg in the rebind of sensitivePositions
+type sensitiveArg struct{}
+
+// sensitivePositions tells which args of the query of key hold a sensitive
+// value. The query is rebound once more with the sensitive args replaced, a
+// list by as many placeholders, so that the positions line up with the
+// expanded args of the query.
+func sensitivePositions(sb *sqlcomposer.SqlBuilder, key string) ([]bool, error) {
+	args := sb.Conditions.Arg
+	shadow := make(map[string]interface{}, len(args))
+	found := false
+	for k, v := range args {
+		if !strings.HasPrefix(k, sensitiveArgPrefix) {
+			shadow[k] = v
+			continue
+		}
+		found = true
+
+		rv := reflect.ValueOf(v)
+		if v == nil || rv.Kind() != reflect.Slice || rv.Type() == reflect.TypeOf([]byte{}) {
+			shadow[k] = sensitiveArg{}
+			continue
+		}
+		list := make([]interface{}, rv.Len())
+		for i := range list {
+			list[i] = sensitiveArg{}
+		}
+		shadow[k] = list
+	}
+	if !found {
+		return nil, nil
+	}
+
+	sb.Conditions.Arg = shadow
+	defer func() { sb.Conditions.Arg = args }()
+
+	_, marked, err := sb.Rebind(key)
+	if err != nil {
+		return nil, err
+	}
+	sensitive := make([]bool, len(marked))
+	for i, a := range marked {
+		_, sensitive[i] = a.(sensitiveArg)
+	}
+	return sensitive, nil
+}
+
+func redactArgs(args []interface{}, sensitive []bool) []interface{} {
+	redacted := make([]interface{}, len(args))
+	for i, a := range args {
+		if i < len(sensitive) && sensitive[i] {
+			redacted[i] = redactedValue
+		} else {
+			redacted[i] = a
+		}
+	}
+	return redacted
+}
+
+// PersistStats writes the queued slow queries to the metadata database as
+// they come, and the stats of the window every interval. When ctx is done
+// the queue and the stats are flushed once more.
+func (s *Service) PersistStats(ctx context.Context, every time.Duration) {
+	var tick <-chan time.Time
+	if every > 0 {
+		ticker := time.NewTicker(every)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			s.drainSlowQueries()
+			if every > 0 {
+				s.flushStats()
+			}
+			return
+		case slow := <-s.slowQueries:
+			s.logSlowQuery(slow)
+		case <-tick:
+			s.flushStats()
+		}
+	}
+}
+
+func (s *Service) drainSlowQueries() {
+	for {
+		select {
+		case slow := <-s.slowQueries:
+			s.logSlowQuery(slow)
+		default:
+			return
+		}
+	}
+}
+
+func (s *Service) flushStats() {
+	for _, stats := range s.stats.snapshot(time.Now()) {
+		_, err := s.Db.NamedExec(`INSERT INTO query_stats (doc_path, composition_key, count, errors, error_rate, rows_returned, p50_ms, p95_ms, max_ms, window_seconds, updated_at)
+			VALUES (:doc_path, :composition_key, :count, :errors, :error_rate, :rows_returned, :p50_ms, :p95_ms, :max_ms, :window_seconds, :updated_at)
+			ON DUPLICATE KEY UPDATE count=VALUES(count), errors=VALUES(errors), error_rate=VALUES(error_rate),
+				rows_returned=VALUES(rows_returned), p50_ms=VALUES(p50_ms), p95_ms=VALUES(p95_ms), max_ms=VALUES(max_ms),
+				window_seconds=VALUES(window_seconds), updated_at=VALUES(updated_at)`, stats)
+		if err != nil {
+			log.Warn(err)
+			return
+		}
+	}
+}
+
+// GetSlowestDocs lists the stats of the window, slowest p95 first
+func (s *Service) GetSlowestDocs(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if err != nil || limit < 1 {
+		c.JSON(http.StatusBadRequest, Error{
+			Code:    40006,
+			Message: "limit must be a positive integer",
+		})
+		return
+	}
+
+	stats := s.stats.snapshot(time.Now())
+	list := QueryStatsList{
+		Data:  []*entity.QueryStats{},
+		Total: int64(len(stats)),
+	}
+	if len(stats) > limit {
+		stats = stats[:limit]
+	}
+	list.Data = append(list.Data, stats...)
+
+	c.JSON(http.StatusOK, list)
+}
+
+// GetSlowQueryList lists the logged slow queries, most recent first
+func (s *Service) GetSlowQueryList(c *gin.Context) {
+	var where string
+	var args []interface{}
+	if path := c.Query("path"); path != "" {
+		where = " WHERE doc_path=?"
+		args = append(args, path)
+	}
+
+	pageIndex, _ := strconv.ParseInt(c.DefaultQuery("page_index", "1"), 10, 64)
+	pageLimit, _ := strconv.ParseInt(c.DefaultQuery("page_limit", "20"), 10, 64)
+	if pageIndex < 1 {
+		pageIndex = 1
+	}
+	if pageLimit < 1 || pageLimit > 500 {
+		pageLimit = 20
+	}
+
+	var list SlowQueryList
+	list.Data = []*entity.SlowQuery{}
+	err := s.Db.Select(&list.Data, "SELECT * FROM slow_query_log"+where+" ORDER BY created_at DESC, id DESC LIMIT ?, ?",
+		append(args, (pageIndex-1)*pageLimit, pageLimit)...)
+	if err != nil {
+		log.Error(err)
+		c.JSON(http.StatusBadRequest, nil)
+		return
+	}
+
+	err = s.Db.Get(&list, "SELECT COUNT(id) AS total FROM slow_query_log"+where, args...)
+	if err != nil {
+		log.Error(err)
+		c.JSON(http.StatusBadRequest, nil)
+		return
+	}
+
+	c.JSON(http.StatusOK, list)
+}
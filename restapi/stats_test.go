@@ -0,0 +1,93 @@
+package restapi
+
+import (
+	"github.com/jmoiron/sqlx"
+	"github.com/wangxb07/sqlcomposer"
+	"reflect"
+	"testing"
+)
+
+// statsBuilder is a builder of subject over conditions, its db is never
+// connected and only rebinds the placeholders
+func statsBuilder(t *testing.T, subject string, conditions sqlcomposer.ConditionStmt) *sqlcomposer.SqlBuilder {
+	t.Helper()
+
+	db, err := sqlx.Open("mysql", unreachableDSN)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sb, err := sqlcomposer.NewSqlBuilder(db, []byte("composition:\n  subject:\n    subject: \"\"\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sb.Doc.Composition.Subject["subject"] = subject
+	sb.SetConditions(&conditions)
+	return sb
+}
+
+func TestSensitivePositions(t *testing.T) {
+	secured := markSensitive(sqlcomposer.ConditionStmt{
+		Clause: "tenant_id = :tenant AND shop_id IN(:shops)",
+		Arg:    map[string]interface{}{"tenant": "t1", "shops": []interface{}{"s1", "s2"}},
+	})
+	conditions := sqlcomposer.Combine(sqlcomposer.AND, sqlcomposer.ConditionStmt{
+		Clause: "state = :state AND tag IN(:tags)",
+		Arg:    map[string]interface{}{"state": "paid", "tags": []string{"a", "b", "c"}},
+	}, secured)
+
+	sb := statsBuilder(t, "SELECT * FROM t WHERE owner = :owner AND %where", conditions)
+	sb.Conditions.Arg["owner"] = "u1"
+	before := sb.Conditions.Arg
+
+	_, args, err := sb.Rebind("subject")
+	if err != nil {
+		t.Fatalf("Rebind: %v", err)
+	}
+	positions, err := sensitivePositions(sb, "subject")
+	if err != nil {
+		t.Fatalf("sensitivePositions: %v", err)
+	}
+	if len(positions) != len(args) {
+		t.Fatalf("%d positions for %d args %v", len(positions), len(args), args)
+	}
+	if !reflect.DeepEqual(sb.Conditions.Arg, before) {
+		t.Errorf("args were left as %v", sb.Conditions.Arg)
+	}
+
+	redacted := redactArgs(args, positions)
+	want := map[interface{}]interface{}{"u1": "u1", "paid": "paid", "a": "a", "b": "b", "c": "c", "t1": redactedValue, "s1": redactedValue, "s2": redactedValue}
+	for i, a := range args {
+		if redacted[i] != want[a] {
+			t.Errorf("arg %d %#v is logged as %#v, want %#v", i, a, redacted[i], want[a])
+		}
+	}
+}
+
+func TestSensitivePositionsWithoutSensitiveArgs(t *testing.T) {
+	sb := statsBuilder(t, "SELECT * FROM t WHERE %where", sqlcomposer.ConditionStmt{
+		Clause: "state = :state",
+		Arg:    map[string]interface{}{"state": "paid"},
+	})
+
+	positions, err := sensitivePositions(sb, "subject")
+	if err != nil || positions != nil {
+		t.Errorf("sensitivePositions returned %v, %v", positions, err)
+	}
+	if got := redactArgs([]interface{}{"paid"}, positions); got[0] != "paid" {
+		t.Errorf("paid is logged as %#v", got[0])
+	}
+}
+
+func TestRedactArgs(t *testing.T) {
+	args := []interface{}{"a", 1, nil, []byte("b")}
+	got := redactArgs(args, []bool{false, true, true})
+
+	want := []interface{}{"a", redactedValue, redactedValue, []byte("b")}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("redacted args are %#v, want %#v", got, want)
+	}
+	if args[1] != 1 {
+		t.Errorf("the args were redacted in place")
+	}
+}
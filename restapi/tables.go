@@ -0,0 +1,85 @@
+package restapi
+
+import (
+	"github.com/jmoiron/sqlx"
+)
+
+// Tables of the metadata database added after doc and database_config
+var tables = []string{
+	`CREATE TABLE IF NOT EXISTS mutation_log (
+		id BIGINT NOT NULL AUTO_INCREMENT,
+		doc_uuid VARCHAR(36) NOT NULL,
+		path VARCHAR(255) NOT NULL,
+		idempotency_key VARCHAR(255) NULL,
+		request_body TEXT NOT NULL,
+		request_hash CHAR(64) NOT NULL DEFAULT '',
+		status VARCHAR(16) NOT NULL,
+		status_code INT NOT NULL DEFAULT 0,
+		response TEXT NULL,
+		client_ip VARCHAR(64) NOT NULL DEFAULT '',
+		created_at INT NULL,
+		updated_at INT NULL,
+		PRIMARY KEY (id),
+		UNIQUE KEY uniq_path_idempotency_key (path, idempotency_key)
+	)`,
+	`CREATE TABLE IF NOT EXISTS audit_log (
+		id BIGINT NOT NULL AUTO_INCREMENT,
+		actor VARCHAR(255) NOT NULL,
+		action VARCHAR(64) NOT NULL,
+		target_type VARCHAR(32) NOT NULL,
+		target_uuid VARCHAR(36) NOT NULL,
+		before_snapshot MEDIUMTEXT NULL,
+		after_snapshot MEDIUMTEXT NULL,
+		client_ip VARCHAR(64) NOT NULL DEFAULT '',
+		created_at BIGINT NOT NULL,
+		PRIMARY KEY (id),
+		KEY idx_actor_created_at (actor, created_at),
+		KEY idx_target_created_at (target_uuid, created_at),
+		KEY idx_created_at (created_at)
+	)`,
+	`CREATE TABLE IF NOT EXISTS doc_explain (
+		doc_uuid VARCHAR(36) NOT NULL,
+		warnings TEXT NOT NULL,
+		analyzed_at BIGINT NOT NULL,
+		PRIMARY KEY (doc_uuid)
+	)`,
+	`CREATE TABLE IF NOT EXISTS query_stats (
+		doc_path VARCHAR(255) NOT NULL,
+		composition_key VARCHAR(255) NOT NULL,
+		count BIGINT NOT NULL,
+		errors BIGINT NOT NULL,
+		error_rate DOUBLE NOT NULL,
+		rows_returned BIGINT NOT NULL,
+		p50_ms DOUBLE NOT NULL,
+		p95_ms DOUBLE NOT NULL,
+		max_ms DOUBLE NOT NULL,
+		window_seconds INT NOT NULL,
+		updated_at BIGINT NOT NULL,
+		PRIMARY KEY (doc_path, composition_key)
+	)`,
+	`CREATE TABLE IF NOT EXISTS slow_query_log (
+		id BIGINT NOT NULL AUTO_INCREMENT,
+		doc_path VARCHAR(255) NOT NULL,
+		composition_key VARCHAR(255) NOT NULL,
+		duration_ms DOUBLE NOT NULL,
+		rows_returned BIGINT NOT NULL,
+		statement MEDIUMTEXT NOT NULL,
+		args TEXT NOT NULL,
+		error TEXT NULL,
+		created_at BIGINT NOT NULL,
+		PRIMARY KEY (id),
+		KEY idx_path_created_at (doc_path, created_at),
+		KEY idx_created_at (created_at)
+	)`,
+}
+
+// EnsureTables creates the tables sql-compose-api needs in the metadata
+// database when they do not exist
+func EnsureTables(db *sqlx.DB) error {
+	for _, t := range tables {
+		if _, err := db.Exec(t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+// Package sqlident validates and quotes sql identifiers. Everything that is
+// spliced into generated sql as a table, column or alias name goes through
+// it; values are bound as parameters instead.
+package sqlident
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	maxLength = 64
+	maxParts  = 2
+)
+
+var (
+	identPattern   = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	nonWordPattern = regexp.MustCompile(`[^A-Za-z0-9_]`)
+)
+
+// Valid reports whether name is a plain identifier: letters, digits and
+// underscores, not starting with a digit, at most 64 characters.
+func Valid(name string) bool {
+	return len(name) <= maxLength && identPattern.MatchString(name)
+}
+
+// ValidQualified reports whether name is an identifier optionally qualified
+// by a table name, e.g. order_id or o.order_id
+func ValidQualified(name string) bool {
+	parts := strings.Split(name, ".")
+	if len(parts) > maxParts {
+		return false
+	}
+	for _, p := range parts {
+		if !Valid(p) {
+			return false
+		}
+	}
+	return true
+}
+
+// Quote validates a possibly qualified identifier and quotes every part of
+// it with backticks
+func Quote(name string) (string, error) {
+	if !ValidQualified(name) {
+		return "", fmt.Errorf("%q is not a valid sql identifier", name)
+	}
+
+	parts := strings.Split(name, ".")
+	for i, p := range parts {
+		parts[i] = "`" + p + "`"
+	}
+	return strings.Join(parts, "."), nil
+}
+
+// Alias derives an identifier from an arbitrary key, e.g. an attribute code,
+// by replacing everything but letters, digits and underscores
+func Alias(key string) (string, error) {
+	alias := nonWordPattern.ReplaceAllString(key, "_")
+	if alias != "" && alias[0] >= '0' && alias[0] <= '9' {
+		alias = "_" + alias
+	}
+	if !Valid(alias) {
+		return "", fmt.Errorf("can not derive an alias from %q", key)
+	}
+	return alias, nil
+}
@@ -0,0 +1,120 @@
+package sqlident
+
+import (
+	"math/rand"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var quotedPattern = regexp.MustCompile("^`[A-Za-z_][A-Za-z0-9_]*`(\\.`[A-Za-z_][A-Za-z0-9_]*`)?$")
+
+// hostile are names a doc or a request may try to splice into sql
+var hostile = []string{
+	"",
+	" ",
+	"1abc",
+	"a b",
+	"a`b",
+	"`a`",
+	"a;DROP TABLE doc",
+	"a--",
+	"a/*x*/",
+	"a#",
+	"a'b",
+	`a"b`,
+	`a\b`,
+	"a.b.c",
+	".a",
+	"a.",
+	"a..b",
+	"a)",
+	"(a",
+	"a=1",
+	"a OR 1=1",
+	"ä",
+	"a\x00",
+	"a\nb",
+	strings.Repeat("a", 65),
+}
+
+func TestQuote(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		want string
+	}{
+		{"order_id", "`order_id`"},
+		{"_x1", "`_x1`"},
+		{"o.order_id", "`o`.`order_id`"},
+		{strings.Repeat("a", 64), "`" + strings.Repeat("a", 64) + "`"},
+	} {
+		got, err := Quote(tc.name)
+		if err != nil || got != tc.want {
+			t.Errorf("Quote(%q) = %q, %v, want %q", tc.name, got, err, tc.want)
+		}
+	}
+
+	for _, name := range hostile {
+		if got, err := Quote(name); err == nil {
+			t.Errorf("Quote(%q) = %q, want an error", name, got)
+		}
+	}
+}
+
+func TestAlias(t *testing.T) {
+	for _, tc := range []struct {
+		key  string
+		want string
+	}{
+		{"color", "color"},
+		{"a-b", "a_b"},
+		{"1st", "_1st"},
+		{"a`;DROP TABLE doc", "a__DROP_TABLE_doc"},
+		{"o.x", "o_x"},
+	} {
+		got, err := Alias(tc.key)
+		if err != nil || got != tc.want {
+			t.Errorf("Alias(%q) = %q, %v, want %q", tc.key, got, err, tc.want)
+		}
+	}
+
+	for _, key := range []string{"", strings.Repeat("a", 65)} {
+		if got, err := Alias(key); err == nil {
+			t.Errorf("Alias(%q) = %q, want an error", key, got)
+		}
+	}
+}
+
+// TestRandomNames checks the invariants of Quote and Alias on random names
+// made of the characters that matter in sql
+func TestRandomNames(t *testing.T) {
+	const alphabet = "aZ_09.`'\";-#/*() =\\\n\x00ä"
+	runes := []rune(alphabet)
+	r := rand.New(rand.NewSource(1))
+
+	for i := 0; i < 20000; i++ {
+		b := make([]rune, r.Intn(12))
+		for j := range b {
+			b[j] = runes[r.Intn(len(runes))]
+		}
+		name := string(b)
+
+		if q, err := Quote(name); err == nil {
+			if !quotedPattern.MatchString(q) {
+				t.Fatalf("Quote(%q) = %q is not a quoted identifier", name, q)
+			}
+			if !ValidQualified(name) {
+				t.Fatalf("Quote(%q) accepted an invalid name", name)
+			}
+		}
+
+		if alias, err := Alias(name); err == nil {
+			if !Valid(alias) {
+				t.Fatalf("Alias(%q) = %q is not a valid identifier", name, alias)
+			}
+			if q, err := Quote(alias); err != nil || !quotedPattern.MatchString(q) {
+				t.Fatalf("Quote(Alias(%q)) = %q, %v", name, q, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package token
+
+import (
+	"fmt"
+	"gitlab.com/beehplus/sql-compose/sqlident"
+	"sync"
+	"time"
+)
+
+// Dictionaries caches key to id lookup tables of target databases. Entries
+// are kept per target database and reloaded once they are older than the
+// TTL, or after Refresh. A TTL of zero disables the cache.
+type Dictionaries struct {
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[dictionaryKey]*dictionaryEntry
+}
+
+type dictionaryKey struct {
+	database string
+	table    string
+	key      string
+	id       string
+}
+
+type dictionaryEntry struct {
+	values   map[string]string
+	loadedAt time.Time
+}
+
+func NewDictionaries(ttl time.Duration) *Dictionaries {
+	return &Dictionaries{
+		ttl:     ttl,
+		entries: map[dictionaryKey]*dictionaryEntry{},
+	}
+}
+
+// Get returns the key column to id column map of table in the target database
+func (d *Dictionaries) Get(t Target, table string, key string, id string) (map[string]string, error) {
+	k := dictionaryKey{database: t.Name, table: table, key: key, id: id}
+
+	d.mu.Lock()
+	e, ok := d.entries[k]
+	d.mu.Unlock()
+	if ok && time.Since(e.loadedAt) < d.ttl {
+		return e.values, nil
+	}
+
+	values, err := loadDictionary(t, table, key, id)
+	if err != nil {
+		return nil, fmt.Errorf("load dictionary %s failure: %v", table, err)
+	}
+
+	d.mu.Lock()
+	d.entries[k] = &dictionaryEntry{values: values, loadedAt: time.Now()}
+	d.mu.Unlock()
+
+	return values, nil
+}
+
+// Refresh drops the cached dictionaries of a target database, or of all
+// target databases when database is empty
+func (d *Dictionaries) Refresh(database string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for k := range d.entries {
+		if database == "" || k.database == database {
+			delete(d.entries, k)
+		}
+	}
+}
+
+func loadDictionary(t Target, table string, key string, id string) (map[string]string, error) {
+	var quoted []interface{}
+	for _, name := range []string{key, id, table} {
+		q, err := sqlident.Quote(name)
+		if err != nil {
+			return nil, err
+		}
+		quoted = append(quoted, q)
+	}
+
+	rows, err := t.DB.Queryx(fmt.Sprintf("SELECT %s, %s FROM %s", quoted...))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := map[string]string{}
+	for rows.Next() {
+		var k, v string
+		if err := rows.Scan(&k, &v); err != nil {
+			return nil, err
+		}
+		values[k] = v
+	}
+	return values, rows.Err()
+}
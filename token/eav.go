@@ -0,0 +1,171 @@
+package token
+
+import (
+	"fmt"
+	"github.com/wangxb07/sqlcomposer"
+	"gitlab.com/beehplus/sql-compose/sqlident"
+	"sort"
+	"strings"
+)
+
+// EAVSpec describes an entity-attribute-value layout. Attribute keys are
+// looked up in the dictionary table, and every requested attribute is joined
+// from the values table onto the base table.
+//
+// A token named attrs declared with an EAVSpec provides two tokens: %attrs
+// renders the LEFT JOINs and %attrs_fields the select list. The doc gives
+// both the same params, each named by an attribute key with the output
+// column name as value.
+type EAVSpec struct {
+	Dictionary struct {
+		Table string `yaml:"table"`
+		Key   string `yaml:"key"`
+		ID    string `yaml:"id"`
+	} `yaml:"dictionary"`
+	Values struct {
+		Table  string `yaml:"table"`
+		Attr   string `yaml:"attr"`
+		Object string `yaml:"object"`
+		Value  string `yaml:"value"`
+	} `yaml:"values"`
+	Base struct {
+		Alias string `yaml:"alias"`
+		ID    string `yaml:"id"`
+	} `yaml:"base"`
+}
+
+func (spec *EAVSpec) validate() error {
+	for name, v := range map[string]string{
+		"dictionary.table": spec.Dictionary.Table,
+		"dictionary.key":   spec.Dictionary.Key,
+		"dictionary.id":    spec.Dictionary.ID,
+		"values.table":     spec.Values.Table,
+		"values.attr":      spec.Values.Attr,
+		"values.object":    spec.Values.Object,
+		"values.value":     spec.Values.Value,
+		"base.alias":       spec.Base.Alias,
+		"base.id":          spec.Base.ID,
+	} {
+		if v == "" {
+			return fmt.Errorf("eav %s is required", name)
+		}
+		if !sqlident.ValidQualified(v) {
+			return fmt.Errorf("eav %s %q is not a valid sql identifier", name, v)
+		}
+	}
+	return nil
+}
+
+// AddEAV registers the join token name and the select token name_fields of
+// spec
+func (b *Binding) AddEAV(name string, spec *EAVSpec) error {
+	if err := spec.validate(); err != nil {
+		return fmt.Errorf("token %s: %v", name, err)
+	}
+
+	b.register(name, func(t Target, params []sqlcomposer.TokenParam) sqlcomposer.TokenReplacer {
+		return &eavJoinTokenReplacer{spec: spec, target: t, params: params}
+	})
+	b.register(name+"_fields", func(t Target, params []sqlcomposer.TokenParam) sqlcomposer.TokenReplacer {
+		return &eavFieldsTokenReplacer{spec: spec, params: params}
+	})
+	return nil
+}
+
+// quote is only used with identifiers checked by validate
+func quote(name string) string {
+	q, _ := sqlident.Quote(name)
+	return q
+}
+
+// eavAliases derives the join alias of every attribute of params. Keys that
+// map to the same alias, e.g. a-b and a_b, are rejected.
+func eavAliases(params []sqlcomposer.TokenParam) (map[string]string, error) {
+	aliases := make(map[string]string, len(params))
+	keys := map[string]string{}
+	for _, p := range params {
+		alias, err := sqlident.Alias(p.Name)
+		if err != nil {
+			return nil, err
+		}
+		if other, ok := keys[alias]; ok {
+			return nil, fmt.Errorf("attributes %s and %s map to the same alias %s", other, p.Name, alias)
+		}
+		keys[alias] = p.Name
+		aliases[p.Name] = alias
+	}
+	return aliases, nil
+}
+
+type eavJoinTokenReplacer struct {
+	spec   *EAVSpec
+	target Target
+	params []sqlcomposer.TokenParam
+	joins  string
+}
+
+func (r *eavJoinTokenReplacer) Prepare(bind Binder) error {
+	ids, err := r.target.Dictionaries.Get(r.target,
+		r.spec.Dictionary.Table, r.spec.Dictionary.Key, r.spec.Dictionary.ID)
+	if err != nil {
+		return err
+	}
+	aliases, err := eavAliases(r.params)
+	if err != nil {
+		return err
+	}
+
+	var joins []string
+	for _, p := range r.params {
+		id, ok := ids[p.Name]
+		if !ok {
+			return fmt.Errorf("attribute %s is not in dictionary %s", p.Name, r.spec.Dictionary.Table)
+		}
+
+		alias := quote(aliases[p.Name])
+
+		joins = append(joins, fmt.Sprintf("LEFT JOIN %s AS %s ON %s.%s = %s AND %s.%s = %s.%s",
+			quote(r.spec.Values.Table), alias,
+			alias, quote(r.spec.Values.Attr), bind(id),
+			alias, quote(r.spec.Values.Object),
+			quote(r.spec.Base.Alias), quote(r.spec.Base.ID)))
+	}
+	sort.Strings(joins)
+	r.joins = strings.Join(joins, " ")
+	return nil
+}
+
+func (r *eavJoinTokenReplacer) TokenReplace(ctx map[string]interface{}) string {
+	return r.joins
+}
+
+type eavFieldsTokenReplacer struct {
+	spec   *EAVSpec
+	params []sqlcomposer.TokenParam
+	fields string
+}
+
+func (r *eavFieldsTokenReplacer) Prepare(bind Binder) error {
+	aliases, err := eavAliases(r.params)
+	if err != nil {
+		return err
+	}
+
+	var fields []string
+	for _, p := range r.params {
+		as, err := sqlident.Quote(p.Value)
+		if err != nil {
+			return err
+		}
+
+		fields = append(fields, fmt.Sprintf("%s.%s AS %s",
+			quote(aliases[p.Name]), quote(r.spec.Values.Value), as))
+	}
+	sort.Strings(fields)
+	r.fields = strings.Join(fields, ",")
+	return nil
+}
+
+func (r *eavFieldsTokenReplacer) TokenReplace(ctx map[string]interface{}) string {
+	return r.fields
+}
@@ -0,0 +1,131 @@
+// Package mes provides the product attribute tokens of the MES schema
+// (fty_dictionary_type, fty_obj_attr and fty_product tables).
+//
+// Import it for its side effect and enable the tokens per database in the
+// token config:
+//
+//	tokens:
+//	  - name: attrs
+//	    plugin: mes_attrs
+//	    databases: [mes]
+package mes
+
+import (
+	"fmt"
+	"github.com/wangxb07/sqlcomposer"
+	"gitlab.com/beehplus/sql-compose/sqlident"
+	"gitlab.com/beehplus/sql-compose/token"
+	"sort"
+	"strings"
+)
+
+func init() {
+	token.Register("mes_attrs", func(t token.Target, params []sqlcomposer.TokenParam) sqlcomposer.TokenReplacer {
+		return &attrsTokenReplacer{
+			Attrs:  paramsToAttrs(params),
+			Target: t,
+		}
+	})
+
+	token.Register("mes_attrs_fields", func(t token.Target, params []sqlcomposer.TokenParam) sqlcomposer.TokenReplacer {
+		return &attrsFieldsTokenReplacer{
+			Attrs: paramsToAttrs(params),
+		}
+	})
+}
+
+func paramsToAttrs(params []sqlcomposer.TokenParam) map[string]string {
+	attrs := map[string]string{}
+	for _, p := range params {
+		attrs[p.Name] = p.Value
+	}
+	return attrs
+}
+
+type attrsTokenReplacer struct {
+	Attrs  map[string]string
+	Target token.Target
+	joins  string
+}
+
+func (atr *attrsTokenReplacer) Prepare(bind token.Binder) error {
+	dt, err := GetMESDictTypes(atr.Target)
+	if err != nil {
+		return err
+	}
+
+	atr.joins, err = ProductAttrsToJoinInStat(dt, atr.Attrs, bind)
+	return err
+}
+
+func (atr *attrsTokenReplacer) TokenReplace(ctx map[string]interface{}) string {
+	return atr.joins
+}
+
+type attrsFieldsTokenReplacer struct {
+	Attrs  map[string]string
+	fields string
+}
+
+func (atr *attrsFieldsTokenReplacer) Prepare(bind token.Binder) (err error) {
+	atr.fields, err = ProductAttrsToSelect(atr.Attrs)
+	return err
+}
+
+func (atr *attrsFieldsTokenReplacer) TokenReplace(ctx map[string]interface{}) string {
+	return atr.fields
+}
+
+// GetMESDictTypes returns the dictionary type code to sid map of the target
+// database
+func GetMESDictTypes(t token.Target) (map[string]string, error) {
+	return t.Dictionaries.Get(t, "fty_dictionary_type", "code", "sid")
+}
+
+func ProductAttrsToJoinInStat(dt map[string]string, a map[string]string, bind token.Binder) (string, error) {
+	var str []string
+
+	for key := range a {
+		alias, err := attrAlias(key)
+		if err != nil {
+			return "", err
+		}
+
+		sid, ok := dt[key]
+
+		if !ok {
+			return "", fmt.Errorf("attribute %s is not a dictionary type", key)
+		}
+
+		str = append(str,
+			fmt.Sprintf(`LEFT JOIN fty_obj_attr AS %s ON %s.attr_sid = %s AND %s.obj_sid = fty_product.sid`,
+				alias, alias, bind(sid), alias))
+	}
+	sort.Strings(str)
+	return strings.Join(str, " "), nil
+}
+
+func ProductAttrsToSelect(a map[string]string) (string, error) {
+	var str []string
+	for key, value := range a {
+		alias, err := attrAlias(key)
+		if err != nil {
+			return "", err
+		}
+		as, err := sqlident.Quote(value)
+		if err != nil {
+			return "", err
+		}
+		str = append(str, fmt.Sprintf("%s.attr_value AS %s", alias, as))
+	}
+	sort.Strings(str)
+	return strings.Join(str, ","), nil
+}
+
+func attrAlias(key string) (string, error) {
+	alias, err := sqlident.Alias(key)
+	if err != nil {
+		return "", err
+	}
+	return sqlident.Quote(alias)
+}
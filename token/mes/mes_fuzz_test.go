@@ -0,0 +1,23 @@
+//go:build go1.18
+// +build go1.18
+
+package mes
+
+import (
+	"gitlab.com/beehplus/sql-compose/internal/sqltest"
+	"testing"
+)
+
+func FuzzProductAttrsToSelect(f *testing.F) {
+	f.Add("color", "color")
+	f.Add("a`; DROP TABLE fty_product; --", "attr_a")
+	f.Add("color", "x' OR '1'='1")
+
+	f.Fuzz(func(t *testing.T, key string, as string) {
+		fields, err := ProductAttrsToSelect(map[string]string{key: as})
+		if err != nil {
+			return
+		}
+		sqltest.AssertSafeSQL(t, fields)
+	})
+}
@@ -0,0 +1,55 @@
+package mes
+
+import (
+	"fmt"
+	"gitlab.com/beehplus/sql-compose/internal/sqltest"
+	"testing"
+)
+
+func TestProductAttrsToJoinInStat(t *testing.T) {
+	keys := []string{"a`; DROP TABLE fty_product; --", "x' OR '1'='1", "color"}
+	sids := []string{"1' OR '1'='1", "2; DELETE FROM doc", "/* 3 */"}
+
+	dt := map[string]string{}
+	attrs := map[string]string{}
+	for i, key := range keys {
+		dt[key] = sids[i]
+		attrs[key] = fmt.Sprintf("attr_%d", i)
+	}
+
+	var bound []interface{}
+	bind := func(v interface{}) string {
+		bound = append(bound, v)
+		return fmt.Sprintf(":arg_%d", len(bound))
+	}
+
+	joins, err := ProductAttrsToJoinInStat(dt, attrs, bind)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqltest.AssertSafeSQL(t, joins, append(keys[:2], sids...)...)
+	if len(bound) != len(keys) {
+		t.Errorf("bound %d sids, want %d", len(bound), len(keys))
+	}
+
+	if _, err := ProductAttrsToJoinInStat(dt, map[string]string{"size": "size"}, bind); err == nil {
+		t.Errorf("an attribute missing from the dictionary was accepted")
+	}
+}
+
+func TestProductAttrsToSelect(t *testing.T) {
+	fields, err := ProductAttrsToSelect(map[string]string{
+		"a`; DROP TABLE fty_product; --": "attr_a",
+		"color":                          "color",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqltest.AssertSafeSQL(t, fields, "a`; DROP TABLE fty_product; --")
+
+	for _, as := range []string{"", "a`b", "x' OR '1'='1", "a; DROP TABLE doc", "a.b.c", "-- x"} {
+		if got, err := ProductAttrsToSelect(map[string]string{"color": as}); err == nil {
+			t.Errorf("output column %q was accepted: %q", as, got)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package token
+
+import (
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/wangxb07/sqlcomposer"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// Target is the database a doc runs against
+type Target struct {
+	// Name of the DataBaseConfig
+	Name         string
+	DB           *sqlx.DB
+	Dictionaries *Dictionaries
+}
+
+// Factory builds the replacer of a plugin token from the params a doc gives
+// the token.
+type Factory func(t Target, params []sqlcomposer.TokenParam) sqlcomposer.TokenReplacer
+
+// Binder binds a value as a query parameter and returns the placeholder to
+// put into the sql in its place
+type Binder func(value interface{}) string
+
+// Preparer is implemented by token replacers that need to load data, bind
+// values or can fail. Prepare is called once before the sql is composed, an
+// error rejects the request.
+type Preparer interface {
+	Prepare(bind Binder) error
+}
+
+var (
+	pluginsMu sync.RWMutex
+	plugins   = map[string]Factory{}
+)
+
+// Register makes a token plugin available by name. It is meant to be called
+// from the init function of the plugin package, like database/sql drivers.
+func Register(name string, factory Factory) {
+	pluginsMu.Lock()
+	defer pluginsMu.Unlock()
+
+	if factory == nil {
+		panic("token: Register factory is nil")
+	}
+	if _, dup := plugins[name]; dup {
+		panic("token: Register called twice for plugin " + name)
+	}
+	plugins[name] = factory
+}
+
+func plugin(name string) (Factory, bool) {
+	pluginsMu.RLock()
+	defer pluginsMu.RUnlock()
+
+	f, ok := plugins[name]
+	return f, ok
+}
+
+// Definition declares a token and the databases it applies to. A token is
+// backed by a registered Go plugin, by a text/template that is executed with
+// the token params of the doc, or by an EAV layout (see EAVSpec).
+type Definition struct {
+	Name      string   `yaml:"name"`
+	Plugin    string   `yaml:"plugin,omitempty"`
+	Template  string   `yaml:"template,omitempty"`
+	EAV       *EAVSpec `yaml:"eav,omitempty"`
+	Databases []string `yaml:"databases"`
+
+	factory Factory
+}
+
+func (d *Definition) appliesTo(dbName string) bool {
+	for _, name := range d.Databases {
+		if name == "*" || name == dbName {
+			return true
+		}
+	}
+	return false
+}
+
+type Registry struct {
+	Tokens       []*Definition `yaml:"tokens"`
+	Dictionaries *Dictionaries `yaml:"-"`
+}
+
+// LoadRegistry reads token definitions from a yaml file. An empty path gives
+// an empty registry, so no token is available to any database. Dictionaries
+// looked up by tokens are cached for dictionaryTTL.
+func LoadRegistry(path string, dictionaryTTL time.Duration) (*Registry, error) {
+	r := &Registry{}
+	if path != "" {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		r, err = ParseRegistry(content)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	r.Dictionaries = NewDictionaries(dictionaryTTL)
+	return r, nil
+}
+
+func ParseRegistry(content []byte) (*Registry, error) {
+	var r Registry
+	if err := yaml.Unmarshal(content, &r); err != nil {
+		return nil, err
+	}
+
+	for _, d := range r.Tokens {
+		if err := d.prepare(); err != nil {
+			return nil, err
+		}
+	}
+
+	r.Dictionaries = NewDictionaries(0)
+	return &r, nil
+}
+
+func (d *Definition) prepare() error {
+	if d.Name == "" {
+		return fmt.Errorf("token name is required")
+	}
+
+	kinds := 0
+	for _, set := range []bool{d.Plugin != "", d.Template != "", d.EAV != nil} {
+		if set {
+			kinds++
+		}
+	}
+	if kinds != 1 {
+		return fmt.Errorf("token %s must declare exactly one of plugin, template or eav", d.Name)
+	}
+
+	if d.EAV != nil {
+		if err := d.EAV.validate(); err != nil {
+			return fmt.Errorf("token %s: %v", d.Name, err)
+		}
+		return nil
+	}
+
+	if d.Plugin != "" {
+		f, ok := plugin(d.Plugin)
+		if !ok {
+			return fmt.Errorf("token %s uses unknown plugin %s", d.Name, d.Plugin)
+		}
+		d.factory = f
+		return nil
+	}
+
+	tpl, err := template.New(d.Name).Funcs(templateFuncs).Parse(d.Template)
+	if err != nil {
+		return fmt.Errorf("token %s template: %v", d.Name, err)
+	}
+	d.factory = templateFactory(tpl)
+	return nil
+}
+
+// Binding tracks the token replacers registered on one SqlBuilder
+type Binding struct {
+	sb        *sqlcomposer.SqlBuilder
+	target    Target
+	replacers []sqlcomposer.TokenReplacer
+}
+
+// Configure registers on sb the tokens that apply to the database dbName
+func (r *Registry) Configure(sb *sqlcomposer.SqlBuilder, dbName string) *Binding {
+	b := &Binding{
+		sb: sb,
+		target: Target{
+			Name:         dbName,
+			DB:           sb.DB,
+			Dictionaries: r.Dictionaries,
+		},
+	}
+
+	for _, d := range r.Tokens {
+		if !d.appliesTo(dbName) {
+			continue
+		}
+
+		if d.EAV != nil {
+			// validated when the registry was loaded
+			b.AddEAV(d.Name, d.EAV)
+			continue
+		}
+
+		b.register(d.Name, d.factory)
+	}
+
+	return b
+}
+
+func (b *Binding) register(name string, factory Factory) {
+	b.sb.RegisterToken(name, func(params []sqlcomposer.TokenParam) sqlcomposer.TokenReplacer {
+		tr := factory(b.target, params)
+		b.replacers = append(b.replacers, tr)
+		return tr
+	})
+}
+
+// Prepare runs Prepare of every registered replacer that implements Preparer.
+// Values bound by the replacers are added to the arguments of the builder, so
+// it must be called after all conditions are set.
+func (b *Binding) Prepare() error {
+	args := map[string]interface{}{}
+	bind := func(value interface{}) string {
+		for i := len(args) + 1; ; i++ {
+			name := fmt.Sprintf("token_arg_%d", i)
+			if _, taken := b.sb.Conditions.Arg[name]; taken {
+				continue
+			}
+			if _, taken := args[name]; taken {
+				continue
+			}
+			args[name] = value
+			return ":" + name
+		}
+	}
+
+	for _, tr := range b.replacers {
+		if p, ok := tr.(Preparer); ok {
+			if err := p.Prepare(bind); err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(args) == 0 {
+		return nil
+	}
+	if b.sb.Conditions.Arg == nil {
+		b.sb.Conditions.Arg = map[string]interface{}{}
+	}
+	for name, value := range args {
+		b.sb.Conditions.Arg[name] = value
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package token
+
+import (
+	"fmt"
+	"github.com/wangxb07/sqlcomposer"
+	"gitlab.com/beehplus/sql-compose/sqlident"
+	"strings"
+	"text/template"
+)
+
+// Template tokens reach their params only through functions, so every param
+// is either validated as an identifier or bound as a value:
+//
+//	LEFT JOIN {{ident "table"}} AS t ON t.type = {{bind "type"}}
+var templateFuncs = template.FuncMap{
+	"ident": func(name string) (string, error) {
+		return "", fmt.Errorf("ident is only available while preparing")
+	},
+	"bind": func(name string) (string, error) {
+		return "", fmt.Errorf("bind is only available while preparing")
+	},
+}
+
+type templateTokenReplacer struct {
+	tpl      *template.Template
+	params   map[string]string
+	rendered string
+}
+
+func templateFactory(tpl *template.Template) Factory {
+	return func(t Target, params []sqlcomposer.TokenParam) sqlcomposer.TokenReplacer {
+		values := map[string]string{}
+		for _, p := range params {
+			values[p.Name] = p.Value
+		}
+		return &templateTokenReplacer{
+			tpl:    tpl,
+			params: values,
+		}
+	}
+}
+
+func (t *templateTokenReplacer) param(name string) (string, error) {
+	v, ok := t.params[name]
+	if !ok {
+		return "", fmt.Errorf("token param %s is not given", name)
+	}
+	return v, nil
+}
+
+func (t *templateTokenReplacer) Prepare(bind Binder) error {
+	tpl, err := t.tpl.Clone()
+	if err != nil {
+		return err
+	}
+
+	tpl.Funcs(template.FuncMap{
+		"ident": func(name string) (string, error) {
+			v, err := t.param(name)
+			if err != nil {
+				return "", err
+			}
+			return sqlident.Quote(v)
+		},
+		"bind": func(name string) (string, error) {
+			v, err := t.param(name)
+			if err != nil {
+				return "", err
+			}
+			return bind(v), nil
+		},
+	})
+
+	var sb strings.Builder
+	if err := tpl.Execute(&sb, nil); err != nil {
+		return err
+	}
+	t.rendered = sb.String()
+	return nil
+}
+
+func (t *templateTokenReplacer) TokenReplace(ctx map[string]interface{}) string {
+	return t.rendered
+}
@@ -0,0 +1,59 @@
+//go:build go1.18
+// +build go1.18
+
+package token
+
+import (
+	"gitlab.com/beehplus/sql-compose/internal/sqltest"
+	"strings"
+	"testing"
+	"text/template"
+)
+
+func FuzzTemplateToken(f *testing.F) {
+	for _, v := range append(sqltest.HostileValues, "o.items", "paid") {
+		f.Add(v, v)
+	}
+
+	tpl := template.Must(template.New("t").Funcs(templateFuncs).Parse(
+		"LEFT JOIN {{ident \"table\"}} AS t ON t.type = {{bind \"type\"}}"))
+	f.Fuzz(func(t *testing.T, table string, typ string) {
+		r := &recorder{}
+		tr := templateFactory(tpl)(Target{}, params("table", table, "type", typ)).(*templateTokenReplacer)
+		if err := tr.Prepare(r.bind); err != nil {
+			return
+		}
+
+		got := tr.TokenReplace(nil)
+		sqltest.AssertSafeSQL(t, got)
+		if !strings.HasSuffix(got, " AS t ON t.type = :arg_1") {
+			t.Errorf("rendered %q", got)
+		}
+		if len(r.values) != 1 || r.values[0] != typ {
+			t.Errorf("bound %v, want [%q]", r.values, typ)
+		}
+	})
+}
+
+func FuzzEAVTokens(f *testing.F) {
+	for _, v := range append(sqltest.HostileValues, "color", "size-2") {
+		f.Add(v, v, v)
+	}
+
+	f.Fuzz(func(t *testing.T, key string, id string, as string) {
+		r := &recorder{}
+		ps := params(key, as)
+		join := &eavJoinTokenReplacer{spec: eavSpec(), target: eavTarget(map[string]string{key: id}), params: ps}
+		if err := join.Prepare(r.bind); err == nil {
+			sqltest.AssertSafeSQL(t, join.TokenReplace(nil))
+			if len(r.values) != 1 || r.values[0] != id {
+				t.Errorf("bound %v, want [%q]", r.values, id)
+			}
+		}
+
+		fields := &eavFieldsTokenReplacer{spec: eavSpec(), params: ps}
+		if err := fields.Prepare(r.bind); err == nil {
+			sqltest.AssertSafeSQL(t, fields.TokenReplace(nil))
+		}
+	})
+}
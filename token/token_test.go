@@ -0,0 +1,160 @@
+package token
+
+import (
+	"fmt"
+	"github.com/wangxb07/sqlcomposer"
+	"gitlab.com/beehplus/sql-compose/internal/sqltest"
+	"strings"
+	"testing"
+	"text/template"
+	"time"
+)
+
+// recorder is a Binder keeping the bound values
+type recorder struct {
+	values []interface{}
+}
+
+func (r *recorder) bind(value interface{}) string {
+	r.values = append(r.values, value)
+	return fmt.Sprintf(":arg_%d", len(r.values))
+}
+
+func params(kv ...string) []sqlcomposer.TokenParam {
+	var ps []sqlcomposer.TokenParam
+	for i := 0; i+1 < len(kv); i += 2 {
+		ps = append(ps, sqlcomposer.TokenParam{Name: kv[i], Value: kv[i+1]})
+	}
+	return ps
+}
+
+func TestTemplateTokenBindsValues(t *testing.T) {
+	tpl := template.Must(template.New("t").Funcs(templateFuncs).Parse(
+		"LEFT JOIN {{ident \"table\"}} AS t ON t.type = {{bind \"type\"}}"))
+
+	for _, v := range sqltest.HostileValues {
+		r := &recorder{}
+		tr := templateFactory(tpl)(Target{}, params("table", "o.items", "type", v)).(*templateTokenReplacer)
+		if err := tr.Prepare(r.bind); err != nil {
+			t.Fatalf("Prepare with type %q: %v", v, err)
+		}
+
+		got := tr.TokenReplace(nil)
+		if want := "LEFT JOIN `o`.`items` AS t ON t.type = :arg_1"; got != want {
+			t.Errorf("rendered %q, want %q", got, want)
+		}
+		if len(r.values) != 1 || r.values[0] != v {
+			t.Errorf("bound %v, want [%q]", r.values, v)
+		}
+		sqltest.AssertSafeSQL(t, got, v)
+	}
+}
+
+func TestTemplateTokenRejectsHostileIdents(t *testing.T) {
+	tpl := template.Must(template.New("t").Funcs(templateFuncs).Parse(
+		"LEFT JOIN {{ident \"table\"}} AS t"))
+
+	for _, v := range append(sqltest.HostileValues, "", "a.b.c", "1a") {
+		tr := templateFactory(tpl)(Target{}, params("table", v)).(*templateTokenReplacer)
+		if err := tr.Prepare((&recorder{}).bind); err == nil {
+			t.Errorf("ident %q was accepted: %q", v, tr.TokenReplace(nil))
+		}
+	}
+
+	tr := templateFactory(tpl)(Target{}, nil).(*templateTokenReplacer)
+	if err := tr.Prepare((&recorder{}).bind); err == nil {
+		t.Errorf("a missing param was accepted")
+	}
+}
+
+func eavSpec() *EAVSpec {
+	spec := &EAVSpec{}
+	spec.Dictionary.Table = "attr_dict"
+	spec.Dictionary.Key = "code"
+	spec.Dictionary.ID = "id"
+	spec.Values.Table = "attr_value"
+	spec.Values.Attr = "attr_id"
+	spec.Values.Object = "object_id"
+	spec.Values.Value = "value"
+	spec.Base.Alias = "p"
+	spec.Base.ID = "id"
+	return spec
+}
+
+// eavTarget serves the dictionary of eavSpec from the cache
+func eavTarget(ids map[string]string) Target {
+	t := Target{Name: "test", Dictionaries: NewDictionaries(time.Hour)}
+	t.Dictionaries.entries[dictionaryKey{database: "test", table: "attr_dict", key: "code", id: "id"}] = &dictionaryEntry{
+		values:   ids,
+		loadedAt: time.Now(),
+	}
+	return t
+}
+
+func TestEAVTokens(t *testing.T) {
+	keys := []string{"color", "a`; DROP TABLE doc; --", "x' OR '1'='1", "size-2"}
+	ids := map[string]string{}
+	var ps []sqlcomposer.TokenParam
+	for i, key := range keys {
+		ids[key] = sqltest.HostileValues[i]
+		ps = append(ps, sqlcomposer.TokenParam{Name: key, Value: fmt.Sprintf("attr_%d", i)})
+	}
+
+	r := &recorder{}
+	join := &eavJoinTokenReplacer{spec: eavSpec(), target: eavTarget(ids), params: ps}
+	if err := join.Prepare(r.bind); err != nil {
+		t.Fatal(err)
+	}
+	// color is a plain identifier, the other keys must only appear as aliases
+	sqltest.AssertSafeSQL(t, join.TokenReplace(nil), append(keys[1:], sqltest.HostileValues...)...)
+	if len(r.values) != len(keys) {
+		t.Errorf("bound %d dictionary ids, want %d", len(r.values), len(keys))
+	}
+	if got := strings.Count(join.TokenReplace(nil), "LEFT JOIN `attr_value` AS "); got != len(keys) {
+		t.Errorf("rendered %d joins, want %d", got, len(keys))
+	}
+
+	fields := &eavFieldsTokenReplacer{spec: eavSpec(), params: ps}
+	if err := fields.Prepare(r.bind); err != nil {
+		t.Fatal(err)
+	}
+	sqltest.AssertSafeSQL(t, fields.TokenReplace(nil), keys[1:]...)
+}
+
+func TestEAVTokensRejectHostileParams(t *testing.T) {
+	ids := map[string]string{"color": "1", "a-b": "2", "a_b": "3"}
+
+	for _, tc := range []struct {
+		name   string
+		params []sqlcomposer.TokenParam
+	}{
+		{"unknown attribute", params("size", "size")},
+		{"colliding aliases", params("a-b", "x", "a_b", "y")},
+	} {
+		join := &eavJoinTokenReplacer{spec: eavSpec(), target: eavTarget(ids), params: tc.params}
+		if err := join.Prepare((&recorder{}).bind); err == nil {
+			t.Errorf("%s: join rendered %q", tc.name, join.TokenReplace(nil))
+		}
+	}
+
+	for _, as := range append(sqltest.HostileValues, "", "a.b.c") {
+		fields := &eavFieldsTokenReplacer{spec: eavSpec(), params: params("color", as)}
+		if err := fields.Prepare((&recorder{}).bind); err == nil {
+			t.Errorf("output column %q was accepted: %q", as, fields.TokenReplace(nil))
+		}
+	}
+	fields := &eavFieldsTokenReplacer{spec: eavSpec(), params: params("a-b", "x", "a_b", "y")}
+	if err := fields.Prepare((&recorder{}).bind); err == nil {
+		t.Errorf("colliding aliases were accepted: %q", fields.TokenReplace(nil))
+	}
+}
+
+func TestEAVSpecRejectsHostileIdents(t *testing.T) {
+	for _, v := range append(sqltest.HostileValues, "", "a.b.c") {
+		spec := eavSpec()
+		spec.Values.Table = v
+		if err := spec.validate(); err == nil {
+			t.Errorf("values.table %q was accepted", v)
+		}
+	}
+}